@@ -12,18 +12,22 @@ import (
 
 	"github.com/influxdata/telegraf/internal"
 
-	"github.com/dcos/dcos-go/dcos/http/transport"
 	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
 )
 
 type DCOSConfig struct {
-	CACertificatePath string `toml:"ca_certificate_path"`
-	IAMConfigPath     string `toml:"iam_config_path"`
-	UserAgent         string `toml:"user_agent"`
+	CACertificatePath     string            `toml:"ca_certificate_path"`
+	IAMConfigPath         string            `toml:"iam_config_path"`
+	IAMTokenRefreshWindow internal.Duration `toml:"iam_token_refresh_window"`
+	TLSMinVersion         string            `toml:"tls_min_version"`
+	UserAgent             string            `toml:"user_agent"`
 }
 
 const defaultUserAgent = "Telegraf"
 
+// versionTLS13 is tls.VersionTLS13, which is not defined before Go 1.12
+const versionTLS13 = 0x0304
+
 // MesosClient returns a *httpcli.Client with TLS and IAM configured according to config.
 func MesosClient(mesosUrl string, config DCOSConfig) (*httpcli.Client, error) {
 	uri := mesosUrl + "/api/v1"
@@ -38,9 +42,7 @@ func MesosClient(mesosUrl string, config DCOSConfig) (*httpcli.Client, error) {
 		if rt, err = config.Transport(); err != nil {
 			return nil, fmt.Errorf("error creating transport: %s", err)
 		}
-		if config.IAMConfigPath != "" {
-			cfgOpts = append(cfgOpts, httpcli.RoundTripper(rt))
-		}
+		cfgOpts = append(cfgOpts, httpcli.RoundTripper(rt))
 	}
 
 	opts = append(opts, httpcli.Do(httpcli.With(cfgOpts...)))
@@ -59,21 +61,19 @@ func GetUserAgent(override string) string {
 
 // Transport returns a transport implementing http.RoundTripper
 func (c *DCOSConfig) Transport() (http.RoundTripper, error) {
-	tr, err := getTransport(c.CACertificatePath)
+	tr, err := getTransport(c.CACertificatePath, c.TLSMinVersion)
 	if err != nil {
 		return nil, err
 	}
 
 	if c.IAMConfigPath != "" {
-		rt, err := transport.NewRoundTripper(
-			tr,
-			transport.OptionReadIAMConfig(c.IAMConfigPath),
-			transport.OptionUserAgent(GetUserAgent(c.UserAgent)),
-		)
+		// IAM tokens are cached per service account, and shared by every
+		// plugin which uses it with the same settings
+		tokens, err := getTokenCache(*c, NewRoundTripper(tr, c.UserAgent))
 		if err != nil {
 			return nil, err
 		}
-		return rt, nil
+		return NewRoundTripper(iamRoundTripper{r: tr, tokens: tokens}, c.UserAgent), nil
 	}
 
 	return tr, nil
@@ -101,7 +101,12 @@ func loadCAPool(path string) (*x509.CertPool, error) {
 }
 
 // getTransport will return transport for http.Client
-func getTransport(caCertificatePath string) (*http.Transport, error) {
+func getTransport(caCertificatePath string, tlsMinVersion string) (*http.Transport, error) {
+	minVersion, err := parseTLSVersion(tlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Printf("I! Loading CA cert: %s", caCertificatePath)
 	caPool, err := loadCAPool(caCertificatePath)
 	if err != nil {
@@ -110,8 +115,24 @@ func getTransport(caCertificatePath string) (*http.Transport, error) {
 
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{
-			RootCAs: caPool,
+			RootCAs:    caPool,
+			MinVersion: minVersion,
 		},
 	}
 	return tr, nil
 }
+
+// parseTLSVersion returns the TLS version named by version. An empty version
+// returns 0, leaving the minimum version to the Go default.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return versionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls_min_version %q; expected \"1.2\" or \"1.3\"", version)
+	}
+}
@@ -0,0 +1,101 @@
+package dcosutil
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mesos/mesos-go/api/v1/lib/agent/calls"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli/httpagent"
+)
+
+// writeCACert writes the certificate of server to a temporary file, so that
+// it can be used as ca_certificate_path, and returns its path
+func writeCACert(t *testing.T, server *httptest.Server) (string, func()) {
+	f, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	f.Close()
+	return f.Name(), func() { os.Remove(f.Name()) }
+}
+
+func TestTransportTLSMinVersion(t *testing.T) {
+	// Any valid certificate will do as a CA
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caPath, teardown := writeCACert(t, server)
+	defer teardown()
+
+	testCases := []struct {
+		tlsMinVersion string
+		expected      uint16
+	}{
+		{"", 0},
+		{"1.2", tls.VersionTLS12},
+		{"1.3", versionTLS13},
+	}
+	for _, tc := range testCases {
+		config := DCOSConfig{CACertificatePath: caPath, TLSMinVersion: tc.tlsMinVersion}
+		rt, err := config.Transport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := rt.(*http.Transport).TLSClientConfig.MinVersion; v != tc.expected {
+			t.Fatalf("Expected MinVersion %x for tls_min_version %q, got %x", tc.expected, tc.tlsMinVersion, v)
+		}
+	}
+
+	config := DCOSConfig{CACertificatePath: caPath, TLSMinVersion: "1.1"}
+	if _, err := config.Transport(); err == nil {
+		t.Fatal("Expected an error for tls_min_version \"1.1\"")
+	}
+}
+
+func TestMesosClientTLS(t *testing.T) {
+	// The server accepts TLS 1.2 at most, so that a client requiring TLS 1.3
+	// is refused whether or not its Go version supports TLS 1.3
+	var requests int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	caPath, teardown := writeCACert(t, server)
+	defer teardown()
+
+	send := func(config DCOSConfig) {
+		client, err := MesosClient(server.URL, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		// The response is not a valid mesos response; only whether the
+		// request reached the server is of interest
+		if resp, err := httpagent.NewSender(client.Send).Send(ctx, calls.NonStreaming(calls.GetHealth())); err == nil {
+			resp.Close()
+		}
+	}
+
+	// The CA is trusted without an IAM config
+	send(DCOSConfig{CACertificatePath: caPath, TLSMinVersion: "1.2"})
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected 1 request to reach the server, got %d", n)
+	}
+
+	// tls_min_version is applied without an IAM config
+	send(DCOSConfig{CACertificatePath: caPath, TLSMinVersion: "1.3"})
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected the TLS 1.3 client to be refused, got %d requests", n)
+	}
+}
@@ -0,0 +1,253 @@
+package dcosutil
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// defaultTokenRefreshWindow is how long before expiry an IAM token is
+	// refreshed, unless configured otherwise
+	defaultTokenRefreshWindow = 5 * time.Minute
+	// loginTokenLifetime is the validity of the token which is signed with
+	// the service account's private key in order to log in
+	loginTokenLifetime = 5 * time.Minute
+	// defaultTokenLifetime is assumed for IAM tokens which carry no expiry
+	defaultTokenLifetime = time.Hour
+)
+
+var (
+	// tokenCaches holds a tokenCache for each service account and set of
+	// transport settings, so that every plugin configured alike shares its
+	// token
+	tokenCaches   = map[tokenCacheKey]*tokenCache{}
+	tokenCachesMu sync.Mutex
+)
+
+// tokenCacheKey identifies the service account, and the settings of the
+// transport used to log in as it
+type tokenCacheKey struct {
+	iamConfigPath     string
+	caCertificatePath string
+	tlsMinVersion     string
+	userAgent         string
+	refreshWindow     time.Duration
+}
+
+// iamConfig is the service account configuration read from iam_config_path
+type iamConfig struct {
+	UID           string `json:"uid"`
+	PrivateKey    string `json:"private_key"`
+	LoginEndpoint string `json:"login_endpoint"`
+}
+
+// tokenCache logs in to the DC/OS IAM as a service account, and caches the
+// resulting token until shortly before it expires. The dcos-go transport keeps
+// a token per round tripper and only replaces it once it has been rejected, so
+// it can neither be shared between plugins nor refreshed ahead of expiry.
+type tokenCache struct {
+	uid           string
+	key           *rsa.PrivateKey
+	loginEndpoint string
+	refreshWindow time.Duration
+	client        *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// getTokenCache returns the shared tokenCache for the service account whose
+// configuration is at c.IAMConfigPath, creating it if necessary. Callers share
+// a cache only if their transport settings and refresh window match, since rt
+// is used for logging in.
+func getTokenCache(c DCOSConfig, rt http.RoundTripper) (*tokenCache, error) {
+	key := tokenCacheKey{
+		iamConfigPath:     c.IAMConfigPath,
+		caCertificatePath: c.CACertificatePath,
+		tlsMinVersion:     c.TLSMinVersion,
+		userAgent:         c.UserAgent,
+		refreshWindow:     c.IAMTokenRefreshWindow.Duration,
+	}
+
+	tokenCachesMu.Lock()
+	defer tokenCachesMu.Unlock()
+
+	if tc, ok := tokenCaches[key]; ok {
+		return tc, nil
+	}
+	tc, err := newTokenCache(c.IAMConfigPath, c.IAMTokenRefreshWindow.Duration, rt)
+	if err != nil {
+		return nil, err
+	}
+	tokenCaches[key] = tc
+	return tc, nil
+}
+
+// newTokenCache reads the service account configuration at path and returns
+// a tokenCache for it
+func newTokenCache(path string, refreshWindow time.Duration, rt http.RoundTripper) (*tokenCache, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg iamConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse IAM config %s: %s", path, err)
+	}
+	if cfg.UID == "" || cfg.PrivateKey == "" || cfg.LoginEndpoint == "" {
+		return nil, fmt.Errorf("IAM config %s must set uid, private_key and login_endpoint", path)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(cfg.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key in IAM config %s: %s", path, err)
+	}
+
+	if refreshWindow <= 0 {
+		refreshWindow = defaultTokenRefreshWindow
+	}
+	return &tokenCache{
+		uid:           cfg.UID,
+		key:           key,
+		loginEndpoint: cfg.LoginEndpoint,
+		refreshWindow: refreshWindow,
+		client:        &http.Client{Transport: rt},
+	}, nil
+}
+
+// Token returns the cached IAM token, logging in again first if the token
+// expires within the refresh window
+func (tc *tokenCache) Token() (string, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.token != "" && time.Now().Add(tc.refreshWindow).Before(tc.expiry) {
+		return tc.token, nil
+	}
+
+	token, expiry, err := tc.login()
+	if err != nil {
+		return "", err
+	}
+	tc.token, tc.expiry = token, expiry
+	return tc.token, nil
+}
+
+// Invalidate discards token if it is still cached, so that the next call to
+// Token logs in again
+func (tc *tokenCache) Invalidate(token string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.token == token {
+		tc.token = ""
+	}
+}
+
+// login exchanges a token signed with the service account's private key for
+// an IAM token, and returns it with its expiry
+func (tc *tokenCache) login() (string, time.Time, error) {
+	loginToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"uid": tc.uid,
+		"exp": time.Now().Add(loginTokenLifetime).Unix(),
+	})
+	signed, err := loginToken.SignedString(tc.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	body, err := json.Marshal(map[string]string{"uid": tc.uid, "token": signed})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp, err := tc.client.Post(tc.loginEndpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("could not log in to %s: %s", tc.loginEndpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("could not log in to %s: %s", tc.loginEndpoint, resp.Status)
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", time.Time{}, fmt.Errorf("could not parse login response from %s: %s", tc.loginEndpoint, err)
+	}
+	if result.Token == "" {
+		return "", time.Time{}, fmt.Errorf("login response from %s contained no token", tc.loginEndpoint)
+	}
+	return result.Token, tokenExpiry(result.Token), nil
+}
+
+// tokenExpiry returns the expiry of an IAM token, read from its exp claim. The
+// token's signature is not verified; that is for the services which accept it.
+func tokenExpiry(token string) time.Time {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err == nil {
+		if exp, ok := claims["exp"].(float64); ok {
+			return time.Unix(int64(exp), 0)
+		}
+	}
+	return time.Now().Add(defaultTokenLifetime)
+}
+
+// iamRoundTripper authenticates each request with a cached IAM token
+type iamRoundTripper struct {
+	r      http.RoundTripper
+	tokens *tokenCache
+}
+
+// RoundTrip is an implementation of the RoundTripper interface.
+func (rt iamRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := rt.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.r.RoundTrip(withToken(req, token, req.Body))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The token was rejected before its expiry; log in again, and retry
+	// the request once if its body can be sent again
+	rt.tokens.Invalidate(token)
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	if token, err = rt.tokens.Token(); err != nil {
+		return resp, nil
+	}
+	body := req.Body
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return rt.r.RoundTrip(withToken(req, token, body))
+}
+
+// withToken returns a copy of req which is authenticated with token and sends
+// body, since RoundTrip must not modify the request
+func withToken(req *http.Request, token string, body io.ReadCloser) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Body = body
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "token="+token)
+	return r
+}
@@ -0,0 +1,254 @@
+package dcosutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// startIAMServer starts a stub IAM which verifies login tokens against key
+// and issues tokens which expire after lifetime. It returns the server and a
+// pointer to the number of logins made.
+func startIAMServer(key *rsa.PrivateKey, lifetime time.Duration) (*httptest.Server, *int32) {
+	logins := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var login struct {
+			UID   string `json:"uid"`
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, err := jwt.Parse(login.Token, func(*jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		if err != nil || login.UID != "telegraf" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		n := atomic.AddInt32(logins, 1)
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"uid": login.UID,
+			"exp": time.Now().Add(lifetime).Unix(),
+			"n":   n,
+		}).SignedString([]byte("secret"))
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	}))
+	return server, logins
+}
+
+// writeIAMConfig writes a service account configuration for key to a
+// temporary directory, and returns its path
+func writeIAMConfig(t *testing.T, key *rsa.PrivateKey, loginEndpoint string) (string, func()) {
+	dir, err := ioutil.TempDir("", "dcosutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	b, _ := json.Marshal(iamConfig{UID: "telegraf", PrivateKey: string(pemKey), LoginEndpoint: loginEndpoint})
+	path := filepath.Join(dir, "service_account.json")
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestIAMRoundTripper(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iam, logins := startIAMServer(key, 10*time.Second)
+	defer iam.Close()
+	path, teardown := writeIAMConfig(t, key, iam.URL)
+	defer teardown()
+
+	tokens, err := newTokenCache(path, time.Second, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lastAuth atomic.Value
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastAuth.Store(r.Header.Get("Authorization"))
+	}))
+	defer testServer.Close()
+
+	c := http.Client{
+		Transport: iamRoundTripper{r: http.DefaultTransport, tokens: tokens},
+	}
+	get := func() {
+		resp, err := c.Get(testServer.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// The token is fetched once and reused while it is valid
+	get()
+	get()
+	if n := atomic.LoadInt32(logins); n != 1 {
+		t.Fatalf("Expected 1 login, got %d", n)
+	}
+	if expected := "token=" + tokens.token; lastAuth.Load() != expected {
+		t.Fatalf("Expected request header: `Authorization: %s`. Got: %s", expected, lastAuth.Load())
+	}
+
+	// The token is refreshed once it expires within the refresh window
+	tokens.refreshWindow = 20 * time.Second
+	get()
+	if n := atomic.LoadInt32(logins); n != 2 {
+		t.Fatalf("Expected 2 logins, got %d", n)
+	}
+	if expected := "token=" + tokens.token; lastAuth.Load() != expected {
+		t.Fatalf("Expected request header: `Authorization: %s`. Got: %s", expected, lastAuth.Load())
+	}
+
+	// An invalidated token is refreshed at the next request
+	tokens.refreshWindow = time.Second
+	tokens.Invalidate(tokens.token)
+	get()
+	if n := atomic.LoadInt32(logins); n != 3 {
+		t.Fatalf("Expected 3 logins, got %d", n)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Minute).Truncate(time.Second)
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"exp": exp.Unix(),
+	}).SignedString([]byte("secret"))
+	if expiry := tokenExpiry(token); !expiry.Equal(exp) {
+		t.Fatalf("Expected expiry %s, got %s", exp, expiry)
+	}
+
+	// Tokens without an expiry are assumed to be valid for the default lifetime
+	expiry := tokenExpiry("opaque")
+	if d := time.Until(expiry); d <= 0 || d > defaultTokenLifetime {
+		t.Fatalf("Expected expiry within %s, got %s", defaultTokenLifetime, expiry)
+	}
+}
+
+func TestGetTokenCacheShared(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, teardown := writeIAMConfig(t, key, "http://198.51.100.1/acs/api/v1/auth/login")
+	defer teardown()
+	defer func() {
+		tokenCachesMu.Lock()
+		tokenCaches = map[tokenCacheKey]*tokenCache{}
+		tokenCachesMu.Unlock()
+	}()
+
+	config := DCOSConfig{IAMConfigPath: path}
+	tc1, err := getTokenCache(config, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc2, err := getTokenCache(config, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc1 != tc2 {
+		t.Fatalf("Expected plugins using %s to share a token cache", path)
+	}
+	if tc1.refreshWindow != defaultTokenRefreshWindow {
+		t.Fatalf("Expected default refresh window %s, got %s", defaultTokenRefreshWindow, tc1.refreshWindow)
+	}
+
+	// Plugins which log in differently do not share a token cache
+	others := []DCOSConfig{
+		{IAMConfigPath: path, CACertificatePath: "/run/dcos/pki/CA/ca-bundle.crt"},
+		{IAMConfigPath: path, TLSMinVersion: "1.3"},
+		{IAMConfigPath: path, IAMTokenRefreshWindow: internal.Duration{Duration: time.Minute}},
+	}
+	for _, other := range others {
+		tc, err := getTokenCache(other, http.DefaultTransport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc == tc1 {
+			t.Fatalf("Expected %+v not to share a token cache with %+v", other, config)
+		}
+	}
+	tc, _ := getTokenCache(others[2], http.DefaultTransport)
+	if tc.refreshWindow != time.Minute {
+		t.Fatalf("Expected refresh window %s, got %s", time.Minute, tc.refreshWindow)
+	}
+}
+
+func TestIAMRoundTripperRetry(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iam, logins := startIAMServer(key, 10*time.Second)
+	defer iam.Close()
+	path, teardown := writeIAMConfig(t, key, iam.URL)
+	defer teardown()
+
+	tokens, err := newTokenCache(path, time.Second, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := tokens.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server rejects the first token as though it had been revoked
+	var mu sync.Mutex
+	var bodies []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+		if r.Header.Get("Authorization") == "token="+revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer testServer.Close()
+
+	c := http.Client{
+		Transport: iamRoundTripper{r: http.DefaultTransport, tokens: tokens},
+	}
+	resp, err := c.Post(testServer.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the request to be retried with a new token, got %s", resp.Status)
+	}
+	if n := atomic.LoadInt32(logins); n != 2 {
+		t.Fatalf("Expected 2 logins, got %d", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || bodies[1] != "payload" {
+		t.Fatalf("Expected the request body to be sent twice, got %q", bodies)
+	}
+}
@@ -830,6 +830,12 @@ func (c *Config) addProcessor(name string, table *ast.Table) error {
 		return err
 	}
 
+	if p, ok := processor.(telegraf.Initializer); ok {
+		if err := p.Init(); err != nil {
+			return fmt.Errorf("Could not initialize processor %s: %s", name, err)
+		}
+	}
+
 	rf := &models.RunningProcessor{
 		Name:      name,
 		Processor: processor,
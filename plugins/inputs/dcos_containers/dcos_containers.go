@@ -26,18 +26,28 @@ const sampleConfig = `
   mesos_agent_url = "http://$NODE_PRIVATE_IP:5051"
   ## The period after which requests to mesos agent should time out
   # timeout = "10s"
+  ## Convert fields measured in seconds to milliseconds, renaming them from
+  ## *_secs to *_ms. Fields measured in bytes are unaffected.
+  # normalize_units = false
   ## The user agent to send with requests
   user_agent = "Telegraf-dcos-containers"
   ## Optional IAM configuration
   # ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
   # iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
+  ## How long before it expires the IAM token is refreshed
+  # iam_token_refresh_window = "5m"
+  ## The minimum TLS version to accept when ca_certificate_path is set;
+  ## "1.2" or "1.3"
+  # tls_min_version = "1.2"
 `
 
 // DCOSContainers describes the options available to this plugin
 type DCOSContainers struct {
 	MesosAgentUrl string
 	Timeout       internal.Duration
-	client        *httpcli.Client
+	// NormalizeUnits converts all *_secs fields to *_ms
+	NormalizeUnits bool `toml:"normalize_units"`
+	client         *httpcli.Client
 	dcosutil.DCOSConfig
 }
 
@@ -101,6 +111,9 @@ func (dc *DCOSContainers) Gather(acc telegraf.Accumulator) error {
 		ts, tsOK := cTS(c)
 		tags := cTags(c)
 		for _, m := range cMeasurements(c) {
+			if dc.NormalizeUnits {
+				normalizeUnits(m.fields)
+			}
 			if len(m.fields) > 0 {
 				if tsOK {
 					acc.AddFields(m.name, m.fields, m.combineTags(tags), ts)
@@ -111,6 +124,12 @@ func (dc *DCOSContainers) Gather(acc telegraf.Accumulator) error {
 		}
 	}
 
+	for frameworkID, count := range countByFramework(gc.Containers) {
+		acc.AddFields("dcos_containers_by_framework",
+			map[string]interface{}{"count": count},
+			map[string]string{"framework_id": frameworkID})
+	}
+
 	return nil
 }
 
@@ -532,6 +551,20 @@ func cTags(c agent.Response_GetContainers_Container) map[string]string {
 	return map[string]string{"container_id": c.ContainerID.Value}
 }
 
+// countByFramework returns the number of containers belonging to each
+// framework. Containers whose framework is not known are counted as unknown.
+func countByFramework(cc []agent.Response_GetContainers_Container) map[string]int {
+	counts := make(map[string]int)
+	for _, c := range cc {
+		frameworkID := c.FrameworkID.Value
+		if frameworkID == "" {
+			frameworkID = "unknown"
+		}
+		counts[frameworkID]++
+	}
+	return counts
+}
+
 // cTS retrieves the timestamp from a Container object as a time rounded to the
 // nearest second. If time is not available, we return now.
 func cTS(c agent.Response_GetContainers_Container) (time.Time, bool) {
@@ -541,6 +574,20 @@ func cTS(c agent.Response_GetContainers_Container) (time.Time, bool) {
 	return time.Now(), false
 }
 
+// normalizeUnits converts each field measured in seconds to milliseconds,
+// renaming it from *_secs to *_ms
+func normalizeUnits(fields map[string]interface{}) {
+	for k, v := range fields {
+		if !strings.HasSuffix(k, "_secs") {
+			continue
+		}
+		if secs, ok := v.(float64); ok {
+			delete(fields, k)
+			fields[strings.TrimSuffix(k, "_secs")+"_ms"] = secs * 1000
+		}
+	}
+}
+
 // setIfNotNil runs get() and adds its value to a map, if not nil
 func setIfNotNil(target map[string]interface{}, key string, get interface{}) error {
 	var val interface{}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/testutil"
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -258,6 +260,82 @@ func TestGather(t *testing.T) {
 	}
 }
 
+func TestGatherNormalizeUnits(t *testing.T) {
+	var acc testutil.Accumulator
+
+	server := startTestServer(t, "normal")
+	defer server.Close()
+
+	dc := DCOSContainers{
+		MesosAgentUrl:  server.URL,
+		Timeout:        internal.Duration{Duration: 100 * time.Millisecond},
+		NormalizeUnits: true,
+	}
+
+	err := acc.GatherError(dc.Gather)
+	assert.Nil(t, err)
+
+	// the conversion is performed at runtime rather than on constants to
+	// avoid floating point discrepancies
+	systemSecs, throttledSecs, userSecs := 34501.45, 352.597023453, 96348.84
+	acc.AssertContainsTaggedFields(t, "cpus",
+		map[string]interface{}{
+			"limit":             8.25,
+			"nr_periods":        uint32(769021),
+			"nr_throttled":      uint32(1046),
+			"system_time_ms":    systemSecs * 1000,
+			"throttled_time_ms": throttledSecs * 1000,
+			"user_time_ms":      userSecs * 1000,
+		},
+		map[string]string{"container_id": "abc123"})
+	// byte fields are unchanged
+	acc.AssertContainsFields(t, "mem",
+		map[string]interface{}{
+			"anon_bytes":        uint64(4845449216),
+			"file_bytes":        uint64(260165632),
+			"limit_bytes":       uint64(7650410496),
+			"mapped_file_bytes": uint64(7159808),
+			"rss_bytes":         uint64(5105614848),
+		})
+}
+
+func TestGatherByFramework(t *testing.T) {
+	var acc testutil.Accumulator
+
+	server := startTestServer(t, "multiple_frameworks")
+	defer server.Close()
+
+	dc := DCOSContainers{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 100 * time.Millisecond},
+	}
+
+	err := acc.GatherError(dc.Gather)
+	assert.Nil(t, err)
+
+	acc.AssertContainsTaggedFields(t, "dcos_containers_by_framework",
+		map[string]interface{}{"count": 2},
+		map[string]string{"framework_id": "framework-a"})
+	acc.AssertContainsTaggedFields(t, "dcos_containers_by_framework",
+		map[string]interface{}{"count": 1},
+		map[string]string{"framework_id": "framework-b"})
+}
+
+func TestCountByFramework(t *testing.T) {
+	cc := []agent.Response_GetContainers_Container{
+		{FrameworkID: mesos.FrameworkID{Value: "framework-a"}},
+		{FrameworkID: mesos.FrameworkID{Value: "framework-a"}},
+		{FrameworkID: mesos.FrameworkID{Value: "framework-b"}},
+		{},
+	}
+	expected := map[string]int{
+		"framework-a": 2,
+		"framework-b": 1,
+		"unknown":     1,
+	}
+	assert.Equal(t, expected, countByFramework(cc))
+}
+
 func TestSetIfNotNil(t *testing.T) {
 	t.Run("Legal set methods which return concrete values", func(t *testing.T) {
 		mmap := make(map[string]interface{})
@@ -80,15 +80,28 @@ func AddContainer(c containers.Controller) http.HandlerFunc {
 			return
 		}
 
+		if ctr.Id == "" {
+			log.Print("I! Could not add container with an empty container_id")
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "container_id must not be empty")
+			return
+		}
+
+		result, err := c.AddContainer(ctr)
 		// If container already exists, redirect
-		_, ok := c.GetContainer(ctr.Id)
-		if ok {
+		if err == containers.ErrContainerExists {
 			log.Printf("I! Could not add container %q as it already exists", ctr.Id)
 			http.Redirect(w, r, "/container/"+ctr.Id, http.StatusSeeOther)
 			return
 		}
-
-		result, err := c.AddContainer(ctr)
+		if err == containers.ErrTooManyContainers {
+			log.Printf("E! could not add container %s: %s", ctr.Id, err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusInsufficientStorage)
+			fmt.Fprintf(w, "Could not add container %s: %s", ctr.Id, err)
+			return
+		}
 		if err != nil {
 			log.Printf("E! could not add container: %s", err)
 			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -112,6 +125,89 @@ func AddContainer(c containers.Controller) http.HandlerFunc {
 	}
 }
 
+// AddResult describes the outcome of adding a single container as part of a
+// batch. Status mirrors the HTTP status which would have been returned had the
+// container been added on its own.
+type AddResult struct {
+	Id        string                `json:"container_id"`
+	Status    int                   `json:"status"`
+	Container *containers.Container `json:"container,omitempty"`
+	Error     string                `json:"error,omitempty"`
+}
+
+// AddContainers adds a batch of containers, starting a statsd server for each.
+// Failure to add one container does not prevent the others from being added;
+// it returns a result for each container in the order they were submitted.
+func AddContainers(c containers.Controller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctrs []containers.Container
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&ctrs); err != nil {
+			log.Printf("E! could not decode json: %s", err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Could not decode request")
+			return
+		}
+
+		results := []AddResult{}
+		for _, ctr := range ctrs {
+			if ctr.Id == "" {
+				log.Print("I! Could not add container with an empty container_id")
+				results = append(results, AddResult{
+					Status: http.StatusBadRequest,
+					Error:  "container_id must not be empty",
+				})
+				continue
+			}
+
+			result, err := c.AddContainer(ctr)
+			// If container already exists, point at the original
+			if err == containers.ErrContainerExists {
+				log.Printf("I! Could not add container %q as it already exists", ctr.Id)
+				existing, _ := c.GetContainer(ctr.Id)
+				results = append(results, AddResult{
+					Id:        ctr.Id,
+					Status:    http.StatusSeeOther,
+					Container: existing,
+				})
+				continue
+			}
+			if err != nil {
+				log.Printf("E! could not add container: %s", err)
+				status := http.StatusInternalServerError
+				if err == containers.ErrTooManyContainers {
+					status = http.StatusInsufficientStorage
+				}
+				results = append(results, AddResult{
+					Id:     ctr.Id,
+					Status: status,
+					Error:  err.Error(),
+				})
+				continue
+			}
+			results = append(results, AddResult{
+				Id:        ctr.Id,
+				Status:    http.StatusCreated,
+				Container: result,
+			})
+		}
+
+		data, err := json.Marshal(results)
+		if err != nil {
+			log.Printf("E! could not encode json: %s", err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Could not describe containers")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
 // RemoveContainer removes the specified container and stops its statsd server
 func RemoveContainer(c containers.Controller) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -137,3 +233,60 @@ func RemoveContainer(c containers.Controller) http.HandlerFunc {
 		}
 	}
 }
+
+// RemoveAllContainers removes every known container and stops their statsd
+// servers. It returns a summary of how many containers were removed.
+func RemoveAllContainers(c containers.Controller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		removed := 0
+		for _, ctr := range c.ListContainers() {
+			if err := c.RemoveContainer(ctr); err != nil {
+				log.Printf("E! could not remove container %s: %s", ctr.Id, err)
+				continue
+			}
+			removed++
+		}
+
+		data, err := json.Marshal(map[string]int{"removed": removed})
+		if err != nil {
+			log.Printf("E! could not encode json: %s", err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Could not summarise removed containers")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// Reload re-reads container definitions from disk, adding any containers which
+// are not already known. It returns a summary of how many containers were
+// added and skipped.
+func Reload(c containers.Controller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		added, skipped, err := c.Reload()
+		if err != nil {
+			log.Printf("E! could not reload containers: %s", err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Could not reload containers")
+			return
+		}
+
+		data, err := json.Marshal(map[string]int{"added": added, "skipped": skipped})
+		if err != nil {
+			log.Printf("E! could not encode json: %s", err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Could not summarise reloaded containers")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Authenticate wraps a handler, only passing requests through to it if they
+// carry an Authorization header with the expected bearer token
+func Authenticate(inner http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			log.Printf("I! Rejected unauthorized request %s %s", r.Method, r.RequestURI)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "Unauthorized")
+			return
+		}
+
+		inner.ServeHTTP(w, r)
+	})
+}
@@ -18,11 +18,16 @@ type Route struct {
 
 type Routes []Route
 
-func NewRouter(c containers.Controller) *mux.Router {
+// NewRouter returns a router serving the command API. If authToken is set,
+// every route except the health check requires it as a bearer token.
+func NewRouter(c containers.Controller, authToken string) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 	for _, route := range routes {
 		var handler http.Handler
 		handler = route.HandlerFunc(c)
+		if authToken != "" && !publicRoutes[route.Name] {
+			handler = Authenticate(handler, authToken)
+		}
 		handler = Logger(handler, route.Name)
 
 		router.
@@ -43,6 +48,11 @@ func Index(_ containers.Controller) http.HandlerFunc {
 	}
 }
 
+// publicRoutes are served without authentication
+var publicRoutes = map[string]bool{
+	"ReportHealth": true,
+}
+
 var routes = Routes{
 	Route{
 		"Index",
@@ -51,6 +61,13 @@ var routes = Routes{
 		Index,
 	},
 
+	Route{
+		"ReportHealth",
+		strings.ToUpper("Get"),
+		"/health",
+		ReportHealth,
+	},
+
 	Route{
 		"ListContainers",
 		strings.ToUpper("Get"),
@@ -58,6 +75,20 @@ var routes = Routes{
 		ListContainers,
 	},
 
+	Route{
+		"AddContainers",
+		strings.ToUpper("Post"),
+		"/containers",
+		AddContainers,
+	},
+
+	Route{
+		"RemoveAllContainers",
+		strings.ToUpper("Delete"),
+		"/containers",
+		RemoveAllContainers,
+	},
+
 	Route{
 		"DescribeContainer",
 		strings.ToUpper("Get"),
@@ -78,4 +109,11 @@ var routes = Routes{
 		"/container/{id}",
 		RemoveContainer,
 	},
+
+	Route{
+		"Reload",
+		strings.ToUpper("Post"),
+		"/reload",
+		Reload,
+	},
 }
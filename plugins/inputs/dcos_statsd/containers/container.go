@@ -8,6 +8,16 @@ type Container struct {
 	Id         string `json:"container_id"`
 	StatsdHost string `json:"statsd_host,omitempty"`
 	StatsdPort int    `json:"statsd_port,omitempty"`
+	// StatsdSocket, if set, is the path of a unix socket on which the server
+	// listens for datagrams instead of a host and port
+	StatsdSocket string `json:"statsd_socket,omitempty"`
+	// StatsdProtocol is the protocol on which the server listens; it is set
+	// when the server is started
+	StatsdProtocol string `json:"statsd_protocol,omitempty"`
+	// MetricSeparator separates the parts of metric names; defaults to "."
+	MetricSeparator string `json:"metric_separator,omitempty"`
+	// ParseDataDogTags enables parsing of dogstatsd tags; defaults to true
+	ParseDataDogTags *bool `json:"parse_datadog_tags,omitempty"`
 	// Server is a telegraf statsd input plugin instance
 	Server *statsd.Statsd `json:"-"`
 }
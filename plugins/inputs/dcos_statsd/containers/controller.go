@@ -1,5 +1,16 @@
 package containers
 
+import "errors"
+
+var (
+	// ErrTooManyContainers is returned by AddContainer when the maximum
+	// number of containers has been reached
+	ErrTooManyContainers = errors.New("maximum number of containers reached")
+	// ErrContainerExists is returned by AddContainer when a container with
+	// the same ID has already been added
+	ErrContainerExists = errors.New("container already exists")
+)
+
 // Controller is the interface for controlling containers. We define it in order
 // to pass a DCOSStatsd instance into the API. We cannot directly require the
 // dcos_statsd package without encountering a circular import.
@@ -8,4 +19,5 @@ type Controller interface {
 	GetContainer(cid string) (*Container, bool)
 	AddContainer(c Container) (*Container, error)
 	RemoveContainer(c Container) error
+	Reload() (added int, skipped int, err error)
 }
@@ -9,7 +9,6 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -36,6 +35,17 @@ containers_dir = "/run/dcos/telegraf/dcos_statsd/containers"
 timeout = "15s"
 ## The hostname or IP address on which to host statsd servers
 statsd_host = "198.51.100.1"
+## Require this bearer token in the Authorization header of command API
+## requests. The /health endpoint is always open.
+#auth_token = ""
+## Remove containers whose statsd server has received no data for this long.
+## Leave unset to never remove idle containers.
+#container_idle_timeout = "1h"
+## The maximum number of containers, each with its own statsd server. Leave
+## unset for no limit.
+#max_containers = 0
+## Percentiles to calculate for timing & histogram stats on each server
+#percentiles = [90]
 `
 
 type DCOSStatsd struct {
@@ -47,9 +57,24 @@ type DCOSStatsd struct {
 	ContainersDir string
 	Timeout       internal.Duration
 	StatsdHost    string
-	apiServer     *http.Server
-	containers    map[string]containers.Container
-	rwmu          sync.RWMutex
+	// AuthToken, if set, is required as a bearer token by the command API
+	AuthToken string `toml:"auth_token"`
+	// ContainerIdleTimeout, if set, is the period after which a container
+	// whose server has received no data is removed
+	ContainerIdleTimeout internal.Duration `toml:"container_idle_timeout"`
+	// MaxContainers, if set, limits the number of containers which can be added
+	MaxContainers int `toml:"max_containers"`
+	// Percentiles are calculated for timings by each container's server
+	Percentiles []int `toml:"percentiles"`
+
+	apiServer  *http.Server
+	containers map[string]containers.Container
+	// rwmu guards containers. It is held for writing while containers are
+	// added, removed or reloaded, so that these cannot interleave.
+	rwmu sync.RWMutex
+	// inMemory is set when ContainersDir cannot be written to, eg because it
+	// is on a read-only mount; container state is then not persisted
+	inMemory bool
 }
 
 // SampleConfig returns the default configuration
@@ -69,7 +94,12 @@ func (ds *DCOSStatsd) Start(acc telegraf.Accumulator) error {
 	if ds.containers == nil {
 		ds.containers = map[string]containers.Container{}
 	}
-	router := api.NewRouter(ds)
+	for _, p := range ds.Percentiles {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("percentile %d must be between 0 and 100", p)
+		}
+	}
+	router := api.NewRouter(ds, ds.AuthToken)
 	ds.apiServer = &http.Server{
 		Handler:      router,
 		Addr:         ds.Listen,
@@ -83,9 +113,13 @@ func (ds *DCOSStatsd) Start(acc telegraf.Accumulator) error {
 			log.Printf("I! %s does not exist and will be created now", ds.ContainersDir)
 			os.MkdirAll(ds.ContainersDir, 0666)
 		}
+		if !isWritable(ds.ContainersDir) {
+			log.Printf("W! %s is not writable; state will not persist", ds.ContainersDir)
+			ds.inMemory = true
+		}
 		// We fail early if something is up with the containers dir
-		// (eg bad permissions)
-		if err := ds.loadContainers(); err != nil {
+		// (eg bad permissions), unless we are not persisting state anyway
+		if _, _, err := ds.loadContainers(); err != nil && !ds.inMemory {
 			return err
 		}
 	} else {
@@ -155,6 +189,7 @@ func (ds *DCOSStatsd) Gather(acc telegraf.Accumulator) error {
 			var cacc telegraf.Accumulator
 			cacc = &containers.Accumulator{Accumulator: &acc, CId: c.Id}
 			defer wg.Done()
+			gatherServerHealth(acc, c)
 			if err := c.Server.Gather(cacc); err != nil {
 				log.Printf("E! Error gathering statsd from %s: %s", c.Id, err)
 			}
@@ -163,9 +198,44 @@ func (ds *DCOSStatsd) Gather(acc telegraf.Accumulator) error {
 	ds.rwmu.RUnlock()
 
 	wg.Wait()
+
+	if ds.ContainerIdleTimeout.Duration > 0 {
+		ds.removeIdleContainers()
+	}
 	return nil
 }
 
+// removeIdleContainers removes every container whose server has not received
+// data within the idle timeout. This cleans up after orchestrators which fail
+// to remove containers when their tasks die.
+func (ds *DCOSStatsd) removeIdleContainers() {
+	for _, c := range ds.ListContainers() {
+		idle := time.Since(c.Server.LastActivity())
+		if idle <= ds.ContainerIdleTimeout.Duration {
+			continue
+		}
+		log.Printf("I! Removing container %s which has been idle for %s", c.Id, idle)
+		if err := ds.RemoveContainer(c); err != nil {
+			log.Printf("E! Could not remove idle container %s: %s", c.Id, err)
+		}
+	}
+}
+
+// gatherServerHealth adds a measurement describing whether the container's
+// statsd server is still listening for packets
+func gatherServerHealth(acc telegraf.Accumulator, c containers.Container) {
+	listening := 0
+	if c.Server.Listening() {
+		listening = 1
+	}
+	acc.AddGauge("dcos_statsd_server",
+		map[string]interface{}{
+			"listening": listening,
+			"port":      c.StatsdPort,
+		},
+		map[string]string{"container_id": c.Id})
+}
+
 // Stop is called when the service plugin needs to stop working
 func (ds *DCOSStatsd) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), ds.Timeout.Duration)
@@ -182,9 +252,11 @@ func (ds *DCOSStatsd) Stop() {
 // ListContainers returns a list of known containers
 func (ds *DCOSStatsd) ListContainers() []containers.Container {
 	ctrs := []containers.Container{}
+	ds.rwmu.RLock()
 	for _, c := range ds.containers {
 		ctrs = append(ctrs, c)
 	}
+	ds.rwmu.RUnlock()
 	return ctrs
 }
 
@@ -203,62 +275,148 @@ func (ds *DCOSStatsd) GetContainer(cid string) (*containers.Container, bool) {
 // fails, it will error and the container will not be added. If the fields are
 // not defined, it wil attempt to start a server on a random port and the
 // default host. If this fails, it will error and the container will not be
-// added. If the operation was successful, it will return the container.
+// added. If the statsd_socket field is defined, the server will instead listen
+// on that unix socket. If a container with the same ID exists, it returns
+// ErrContainerExists. If max_containers has been reached, it returns
+// ErrTooManyContainers. If the operation was successful, it will return the
+// container.
 func (ds *DCOSStatsd) AddContainer(ctr containers.Container) (*containers.Container, error) {
+	// The lock is held until the container is stored, so that concurrent
+	// calls cannot all pass the existence and max_containers checks
+	ds.rwmu.Lock()
+	defer ds.rwmu.Unlock()
+	return ds.addContainer(ctr)
+}
+
+// addContainer adds a container as described by AddContainer. The caller must
+// hold rwmu for writing.
+func (ds *DCOSStatsd) addContainer(ctr containers.Container) (*containers.Container, error) {
+	if ctr.Id == "" {
+		return nil, errors.New("container_id must not be empty")
+	}
+
+	if _, ok := ds.containers[ctr.Id]; ok {
+		return nil, containers.ErrContainerExists
+	}
+
+	if ds.MaxContainers > 0 && len(ds.containers) >= ds.MaxContainers {
+		log.Printf("E! Could not add container %s: limit of %d containers reached", ctr.Id, ds.MaxContainers)
+		return nil, containers.ErrTooManyContainers
+	}
+
+	separator := ctr.MetricSeparator
+	if separator == "" {
+		separator = "."
+	}
+	if len(separator) != 1 {
+		return nil, fmt.Errorf("metric separator %q must be a single character", separator)
+	}
+
+	parseDataDogTags := true
+	if ctr.ParseDataDogTags != nil {
+		parseDataDogTags = *ctr.ParseDataDogTags
+	}
+
 	ctr.Server = &statsd.Statsd{
 		Protocol:               "udp",
-		ServiceAddress:         fmt.Sprintf(":%d", ctr.StatsdPort),
-		ParseDataDogTags:       true,
+		ServiceAddress:         net.JoinHostPort(ctr.StatsdHost, strconv.Itoa(ctr.StatsdPort)),
+		ParseDataDogTags:       parseDataDogTags,
 		AllowedPendingMessages: 10000,
-		MetricSeparator:        ".",
+		MetricSeparator:        separator,
+		Percentiles:            ds.Percentiles,
 	}
 
-	// statsd will crash the whole Telegraf process if it attempts to listen on
-	// an occupied port. We therefore check ports in advance if specified by the
-	// user.
-	if ctr.StatsdPort != 0 && !checkPort(ctr.StatsdPort) {
-		log.Printf("E! Attempted to start a server on an occupied port: %d", ctr.StatsdPort)
-		return nil, fmt.Errorf("could not start server on occupied port %d", ctr.StatsdPort)
+	if ctr.StatsdSocket != "" {
+		return ds.addSocketContainer(ctr)
 	}
 
+	// statsd will crash the whole Telegraf process if it fails to listen on
+	// its address, eg because the port is occupied. We therefore bind the
+	// address ourselves and hand the listener to the server, so that losing a
+	// race for the port returns an error instead.
+	addr, err := net.ResolveUDPAddr("udp", ctr.Server.ServiceAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Printf("E! Could not listen on %s: %s", ctr.Server.ServiceAddress, err)
+		return nil, fmt.Errorf("could not start server on address %s: %s", ctr.Server.ServiceAddress, err)
+	}
+	ctr.Server.UDPlistener = conn
+
 	// Statsd.Start discards its accumulator
 	var acc telegraf.Accumulator
 	if err := ctr.Server.Start(acc); err != nil {
 		log.Printf("E! Could not start server for container %s", ctr.Id)
+		conn.Close()
 		return nil, err
 	}
 	log.Printf("I! Added container %s", ctr.Id)
 
+	// A container without a host is bound to the wildcard address, and
+	// advertises the default host
+	bindHost := ctr.StatsdHost
 	if ctr.StatsdHost == "" {
 		ctr.StatsdHost = ds.StatsdHost
 	}
 
 	if ctr.StatsdPort == 0 {
-		port, err := getStatsdServerPort(ctr.Server)
-		if err != nil {
-			log.Printf("E! Could not find port for container %s: %s", ctr.Id, err)
-			return nil, err
-		}
-		ctr.StatsdPort = port
+		ctr.StatsdPort = conn.LocalAddr().(*net.UDPAddr).Port
+	}
+
+	return ds.storeContainer(ctr, bindHost)
+}
+
+// addSocketContainer starts a server listening for datagrams on the
+// container's unix socket. Any file left at the socket path, eg by a previous
+// run, is removed first.
+func (ds *DCOSStatsd) addSocketContainer(ctr containers.Container) (*containers.Container, error) {
+	ctr.Server.Protocol = "unixgram"
+	ctr.Server.ServiceAddress = ctr.StatsdSocket
+
+	if err := os.Remove(ctr.StatsdSocket); err != nil && !os.IsNotExist(err) {
+		log.Printf("E! Could not remove stale socket %s: %s", ctr.StatsdSocket, err)
+		return nil, err
 	}
 
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: ctr.StatsdSocket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("E! Could not listen on %s: %s", ctr.StatsdSocket, err)
+		return nil, fmt.Errorf("could not start server on socket %s: %s", ctr.StatsdSocket, err)
+	}
+	ctr.Server.UnixgramListener = conn
+
+	// Statsd.Start discards its accumulator
+	var acc telegraf.Accumulator
+	if err := ctr.Server.Start(acc); err != nil {
+		log.Printf("E! Could not start server for container %s", ctr.Id)
+		conn.Close()
+		return nil, err
+	}
+	log.Printf("I! Added container %s", ctr.Id)
+
+	return ds.storeContainer(ctr, ctr.StatsdHost)
+}
+
+// storeContainer persists a container whose server has been started and adds
+// it to the set of known containers. It is persisted with bindHost rather than
+// the host it advertises, so that it is bound to the same address when loaded.
+// The caller must hold rwmu for writing.
+func (ds *DCOSStatsd) storeContainer(ctr containers.Container, bindHost string) (*containers.Container, error) {
+	ctr.StatsdProtocol = ctr.Server.Protocol
+
 	// Write container definition to disk
-	if ds.ContainersDir != "" {
-		data, err := json.Marshal(ctr)
-		if err != nil {
-			log.Printf("E! Could not marshal container %s to json: %s", ctr.Id, err)
-			return nil, err
-		}
-		err = ioutil.WriteFile(ds.ContainersDir+"/"+ctr.Id, data, 0666)
-		if err != nil {
+	if ds.persistent() {
+		persisted := ctr
+		persisted.StatsdHost = bindHost
+		if err := ds.writeContainer(persisted); err != nil {
 			log.Printf("E! Could not write container %s to disk: %s", ctr.Id, err)
 			return nil, err
 		}
 	}
 
-	ds.rwmu.Lock()
 	ds.containers[ctr.Id] = ctr
-	ds.rwmu.Unlock()
 
 	return &ctr, nil
 }
@@ -266,12 +424,15 @@ func (ds *DCOSStatsd) AddContainer(ctr containers.Container) (*containers.Contai
 // Remove container will remove a container and stop any associated server. the
 // host and port need not be present in the container argument.
 func (ds *DCOSStatsd) RemoveContainer(c containers.Container) error {
-	ctr, ok := ds.GetContainer(c.Id)
+	ds.rwmu.Lock()
+	defer ds.rwmu.Unlock()
+
+	ctr, ok := ds.containers[c.Id]
 	if !ok {
 		return fmt.Errorf("container %s not found", c.Id)
 	}
 
-	if ds.ContainersDir != "" {
+	if ds.persistent() {
 		if err := os.Remove(ds.ContainersDir + "/" + c.Id); err != nil {
 			log.Printf("E! Could not remove container file %s from disk: %s", c.Id, err)
 			return err
@@ -279,78 +440,144 @@ func (ds *DCOSStatsd) RemoveContainer(c containers.Container) error {
 	}
 	ctr.Server.Stop()
 
-	ds.rwmu.Lock()
+	// Unlike stream sockets, datagram sockets are not unlinked on close
+	if ctr.StatsdSocket != "" {
+		if err := os.Remove(ctr.StatsdSocket); err != nil && !os.IsNotExist(err) {
+			log.Printf("E! Could not remove socket %s: %s", ctr.StatsdSocket, err)
+		}
+	}
+
 	delete(ds.containers, c.Id)
-	ds.rwmu.Unlock()
 
 	return nil
 }
 
-// loadContainers loads containers from disk
-func (ds *DCOSStatsd) loadContainers() error {
+// Reload re-reads the containers dir, adding any containers which are not
+// already known. It returns the number of containers added and the number of
+// container files skipped, either because the container was already known or
+// because it could not be loaded.
+func (ds *DCOSStatsd) Reload() (int, int, error) {
+	if ds.ContainersDir == "" {
+		return 0, 0, errors.New("no containers_dir was set")
+	}
+	return ds.loadContainers()
+}
+
+// loadContainers loads containers from disk, skipping those which are already
+// known. It returns the number of containers added and skipped. rwmu is held
+// throughout, so containers cannot be added or removed concurrently.
+func (ds *DCOSStatsd) loadContainers() (int, int, error) {
+	ds.rwmu.Lock()
+	defer ds.rwmu.Unlock()
+
+	added, skipped := 0, 0
 	files, err := ioutil.ReadDir(ds.ContainersDir)
 	if err != nil {
 		log.Printf("E! The specified containers dir was not available: %s", err)
-		return err
+		return added, skipped, err
 	}
 
 	for _, fInfo := range files {
+		// Skip temporary and set-aside files, which are hidden
+		if fInfo.IsDir() || strings.HasPrefix(fInfo.Name(), ".") {
+			continue
+		}
+
 		// No need for filepath.Join - this simple concat works on Windows
 		fPath := fmt.Sprintf("%s/%s", ds.ContainersDir, fInfo.Name())
 
-		// Attempt to open file
-		file, err := os.Open(fPath)
+		// Attempt to read file
+		data, err := ioutil.ReadFile(fPath)
 		if err != nil {
 			log.Printf("E! The specified file %s could not be opened: %s", fPath, err)
+			skipped++
 			continue
 		}
-		defer file.Close()
 
 		// Consume file as JSON
 		var ctr containers.Container
-		decoder := json.NewDecoder(file)
-		if err := decoder.Decode(&ctr); err != nil {
+		if err := json.Unmarshal(data, &ctr); err != nil {
 			log.Printf("E! The container file %s could not be decoded: %s", fPath, err)
+			// Move the file aside so that it can be inspected later
+			corruptPath := fmt.Sprintf("%s/.%s.corrupt", ds.ContainersDir, fInfo.Name())
+			if err := os.Rename(fPath, corruptPath); err != nil {
+				log.Printf("E! Could not move %s aside: %s", fPath, err)
+			} else {
+				log.Printf("I! Moved undecodable container file %s to %s", fPath, corruptPath)
+			}
+			skipped++
+			continue
+		}
+
+		// Earlier versions, which did not persist statsd_protocol, bound
+		// every container to the wildcard address and persisted the default
+		// host of containers which did not specify one
+		if ctr.StatsdProtocol == "" && ctr.StatsdHost == ds.StatsdHost {
+			ctr.StatsdHost = ""
+		}
+
+		// Leave containers we already know about untouched
+		if _, ok := ds.containers[ctr.Id]; ok {
+			skipped++
 			continue
 		}
 
 		// Finally, add container to cache
-		if _, err := ds.AddContainer(ctr); err != nil {
+		if _, err := ds.addContainer(ctr); err != nil {
 			log.Printf("E! Could not add container %s: %s", ctr.Id, err)
+			skipped++
 			continue
 		}
 		log.Printf("I! Loaded container %s from disk", ctr.Id)
+		added++
 	}
-	return nil
+	return added, skipped, nil
 }
 
-// getStatsdServerPort waits for the statsd server to start up, then returns
-// the port on which it is running, or times out.
-func getStatsdServerPort(s *statsd.Statsd) (int, error) {
-	select {
-	case <-time.After(time.Second):
-		return 0, errors.New("timed out waiting for statsd server to start")
-	case addr := <-s.ListenAddr:
-		su, err := url.Parse("http://" + addr.String())
-		if err != nil {
-			return 0, err
-		}
+// writeContainer writes a container definition to disk. The definition is
+// written to a temporary file in the same directory and then renamed into
+// place, so that a crash mid-write cannot leave a truncated file behind.
+func (ds *DCOSStatsd) writeContainer(ctr containers.Container) error {
+	data, err := json.Marshal(ctr)
+	if err != nil {
+		return err
+	}
 
-		return strconv.Atoi(su.Port())
+	tmp, err := ioutil.TempFile(ds.ContainersDir, "."+ctr.Id+".tmp")
+	if err != nil {
+		return err
 	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), ds.ContainersDir+"/"+ctr.Id)
+}
+
+// persistent returns true if container state should be written to disk
+func (ds *DCOSStatsd) persistent() bool {
+	return ds.ContainersDir != "" && !ds.inMemory
 }
 
-// checkPort checks that a port is free.
-// statsd.listenUDP will throw Fatal if it attempts to listen on a port which
-// was already bound. As we cannot guarantee that a port is always free, since
-// other processes are running on our machines, we need to check ahead of time.
-func checkPort(port int) bool {
-	addr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
-	ln, err := net.ListenUDP("udp", addr)
+// isWritable checks whether files can be created in dir
+func isWritable(dir string) bool {
+	f, err := ioutil.TempFile(dir, ".probe")
 	if err != nil {
 		return false
 	}
-	ln.Close()
+	f.Close()
+	os.Remove(f.Name())
 	return true
 }
 
@@ -10,10 +10,13 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs/dcos_statsd/api"
 	"github.com/influxdata/telegraf/plugins/inputs/dcos_statsd/containers"
 	"github.com/influxdata/telegraf/testutil"
 	"github.com/stretchr/testify/assert"
@@ -42,7 +45,7 @@ func TestStart(t *testing.T) {
 		// Create JSON in memory:
 		ctrport := findFreePort()
 		ctrjson := fmt.Sprintf(
-			`{"container_id":"abc123","statsd_host":"127.0.0.1","statsd_port":%d}`,
+			`{"container_id":"abc123","statsd_host":"127.0.0.1","statsd_port":%d,"statsd_protocol":"udp"}`,
 			ctrport)
 
 		// Write JSON to disk:
@@ -62,6 +65,65 @@ func TestStart(t *testing.T) {
 		assertResponseWas(t, resp, err, fmt.Sprintf("[%s]", ctrjson))
 	})
 
+	t.Run("Server with a partially written container", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "containers")
+		if err != nil {
+			assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+		}
+		defer os.RemoveAll(dir)
+
+		// Write truncated JSON to disk, as a crash mid-write might:
+		err = ioutil.WriteFile(dir+"/abc123", []byte(`{"container_id":"abc`), 0666)
+		if err != nil {
+			assert.Fail(t, fmt.Sprintf("Could not write container state: %s", err))
+		}
+
+		ds := DCOSStatsd{ContainersDir: dir}
+		addr := startTestServer(t, &ds)
+		defer ds.Stop()
+
+		// The container was not loaded
+		resp, err := http.Get(addr + "/containers")
+		assertResponseWas(t, resp, err, "[]")
+
+		// The file was moved aside rather than left in place
+		_, err = os.Stat(dir + "/abc123")
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(dir + "/.abc123.corrupt")
+		assert.Nil(t, err)
+	})
+
+	t.Run("Server with an unwritable containers dir", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "containers")
+		if err != nil {
+			assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+		}
+		defer os.RemoveAll(dir)
+
+		// A directory cannot be created beneath a regular file, which
+		// simulates a read-only mount even when tests are run as root
+		file := dir + "/file"
+		err = ioutil.WriteFile(file, []byte{}, 0444)
+		if err != nil {
+			assert.Fail(t, fmt.Sprintf("Could not create file: %s", err))
+		}
+
+		ds := DCOSStatsd{ContainersDir: file + "/containers", StatsdHost: "127.0.0.1"}
+		addr := startTestServer(t, &ds)
+		defer ds.Stop()
+		assert.True(t, ds.inMemory)
+
+		// Containers can still be added and removed
+		abcjson := `{"container_id":"abc123"}`
+		resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, 1, len(ds.containers))
+
+		_, err = httpDelete(t, addr+"/container/abc123")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(ds.containers))
+	})
 }
 
 func TestStop(t *testing.T) {
@@ -125,7 +187,7 @@ func TestGather(t *testing.T) {
 
 	t.Log("A container on a known port")
 	xyzport := findFreePort()
-	xyzjson := fmt.Sprintf(`{"container_id":"xyz123","statsd_host":"127.0.0.1","statsd_port":%d}`, xyzport)
+	xyzjson := fmt.Sprintf(`{"container_id":"xyz123","statsd_host":"127.0.0.1","statsd_port":%d,"statsd_protocol":"udp"}`, xyzport)
 	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(xyzjson)))
 	assert.Nil(t, err)
 	xyz := parseContainer(t, resp.Body)
@@ -191,6 +253,667 @@ func TestGather(t *testing.T) {
 
 }
 
+func TestGatherServerHealth(t *testing.T) {
+	var acc testutil.Accumulator
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	abcjson := `{"container_id":"abc123"}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	abc := parseContainer(t, resp.Body)
+
+	err = acc.GatherError(ds.Gather)
+	assert.Nil(t, err)
+
+	acc.AssertContainsTaggedFields(t, "dcos_statsd_server",
+		map[string]interface{}{
+			"listening": 1,
+			"port":      abc.StatsdPort,
+		},
+		map[string]string{"container_id": "abc123"})
+}
+
+func TestContainerIdleTimeout(t *testing.T) {
+	var acc testutil.Accumulator
+	ds := DCOSStatsd{
+		StatsdHost:           "127.0.0.1",
+		ContainerIdleTimeout: internal.Duration{Duration: 500 * time.Millisecond},
+	}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	var xyz containers.Container
+	for _, cid := range []string{"abc123", "xyz123"} {
+		ctrjson := fmt.Sprintf(`{"container_id":%q}`, cid)
+		resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(ctrjson)))
+		assert.Nil(t, err)
+		xyz = parseContainer(t, resp.Body)
+	}
+
+	// Let both containers go idle, then send data to only one of them
+	time.Sleep(time.Second)
+	conn := dialUDPPort(t, xyz.StatsdPort)
+	defer conn.Close()
+	_, err := conn.Write([]byte("foo:1|c"))
+	assert.Nil(t, err)
+	err = waitFor(func() bool {
+		ctr, _ := ds.GetContainer("xyz123")
+		return time.Since(ctr.Server.LastActivity()) < ds.ContainerIdleTimeout.Duration
+	})
+	assert.Nil(t, err)
+
+	err = acc.GatherError(ds.Gather)
+	assert.Nil(t, err)
+
+	// The idle container was removed; the active container remains
+	_, ok := ds.GetContainer("abc123")
+	assert.False(t, ok)
+	_, ok = ds.GetContainer("xyz123")
+	assert.True(t, ok)
+}
+
+func TestMaxContainers(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", MaxContainers: 1}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	abcjson := `{"container_id":"abc123"}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// A new container is rejected once the limit is reached
+	xyzjson := `{"container_id":"xyz123"}`
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(xyzjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+	_, ok := ds.GetContainer("xyz123")
+	assert.False(t, ok)
+
+	// An existing container is still redirected to, and the redirect followed
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "abc123", parseContainer(t, resp.Body).Id)
+
+	assert.Equal(t, 1, len(ds.ListContainers()))
+}
+
+func TestMaxContainersConcurrent(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", MaxContainers: 3}
+	startTestServer(t, &ds)
+	defer ds.Stop()
+
+	// Many containers are added at once; only max_containers should succeed
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.AddContainer(containers.Container{Id: fmt.Sprintf("ctr%d", i)})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	rejected := 0
+	for err := range errs {
+		if err == containers.ErrTooManyContainers {
+			rejected++
+		}
+	}
+	assert.Equal(t, 17, rejected)
+	assert.Equal(t, 3, len(ds.ListContainers()))
+}
+
+func TestAddContainerStatsdSocket(t *testing.T) {
+	var acc testutil.Accumulator
+	dir, err := ioutil.TempDir("", "sockets")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	socket := dir + "/statsd.sock"
+	abcjson := fmt.Sprintf(`{"container_id":"abc123","statsd_socket":%q}`, socket)
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	abc := parseContainer(t, resp.Body)
+	assert.Equal(t, socket, abc.StatsdSocket)
+	assert.Empty(t, abc.StatsdPort)
+
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not dial socket: %s", err))
+	}
+	_, err = conn.Write([]byte("foo:1|c"))
+	assert.Nil(t, err)
+	conn.Close()
+
+	err = waitFor(func() bool {
+		acc.GatherError(ds.Gather)
+		return acc.HasMeasurement("foo")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "abc123", acc.TagValue("foo", "container_id"))
+
+	// The socket is cleaned up when the container is removed
+	_, err = httpDelete(t, addr+"/container/abc123")
+	assert.Nil(t, err)
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPercentiles(t *testing.T) {
+	t.Run("Percentiles are passed to each server", func(t *testing.T) {
+		ds := DCOSStatsd{StatsdHost: "127.0.0.1", Percentiles: []int{50, 95, 99}}
+		addr := startTestServer(t, &ds)
+		defer ds.Stop()
+
+		abcjson := `{"container_id":"abc123"}`
+		resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		ctr, ok := ds.GetContainer("abc123")
+		assert.True(t, ok)
+		assert.Equal(t, []int{50, 95, 99}, ctr.Server.Percentiles)
+	})
+
+	t.Run("Percentiles outside 0-100 are rejected", func(t *testing.T) {
+		for _, p := range []int{-1, 101} {
+			ds := DCOSStatsd{Percentiles: []int{50, p}}
+			err := ds.Start(&testutil.Accumulator{})
+			assert.NotNil(t, err)
+		}
+	})
+}
+
+func TestDescribeContainer(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	abcjson := `{"container_id":"abc123"}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	added := parseContainer(t, resp.Body)
+
+	// The server's actual address is returned as soon as it is added
+	assert.Equal(t, "127.0.0.1", added.StatsdHost)
+	assert.NotZero(t, added.StatsdPort)
+	assert.Equal(t, "udp", added.StatsdProtocol)
+
+	resp, err = http.Get(addr + "/container/abc123")
+	assert.Nil(t, err)
+	described := parseContainer(t, resp.Body)
+	assert.Equal(t, added, described)
+}
+
+func TestAddContainerEmptyId(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", ContainersDir: dir}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	for _, ctrjson := range []string{`{}`, `{"container_id":""}`} {
+		resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(ctrjson)))
+		assertResponseWas(t, resp, err, "container_id must not be empty")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// No server was started and no file was written
+	assert.Equal(t, 0, len(ds.ListContainers()))
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestAddContainerConcurrentPort(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	startTestServer(t, &ds)
+	defer ds.Stop()
+
+	// Many containers race for the same port; exactly one should win, and
+	// the others should fail without taking down the process
+	port := findFreePort()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.AddContainer(containers.Container{
+				Id:         fmt.Sprintf("ctr%d", i),
+				StatsdHost: "127.0.0.1",
+				StatsdPort: port,
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	failed := 0
+	for err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	assert.Equal(t, 19, failed)
+	assert.Equal(t, 1, len(ds.ListContainers()))
+}
+
+func TestAddContainerConcurrentSameId(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	// Two requests to add the same container race; one should create it and
+	// the other should be redirected to it, rather than starting a second
+	// server for the same container
+	var wg sync.WaitGroup
+	statuses := make(chan int, 2)
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Post(addr+"/container", "application/json",
+				bytes.NewBuffer([]byte(`{"container_id":"abc123"}`)))
+			assert.Nil(t, err)
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{http.StatusCreated: 1, http.StatusSeeOther: 1}, counts)
+	assert.Equal(t, 1, len(ds.ListContainers()))
+
+	// Adding the container directly reports that it exists
+	_, err := ds.AddContainer(containers.Container{Id: "abc123"})
+	assert.Equal(t, containers.ErrContainerExists, err)
+}
+
+func TestAddContainerIPv6(t *testing.T) {
+	// Skip if the IPv6 loopback is not available in this environment
+	ln, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skip("IPv6 loopback is not available")
+	}
+	ln.Close()
+
+	ds := DCOSStatsd{}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	port := findFreePort()
+	ctrjson := fmt.Sprintf(`{"container_id":"abc123","statsd_host":"::1","statsd_port":%d}`, port)
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(ctrjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	abc := parseContainer(t, resp.Body)
+	assert.Equal(t, "::1", abc.StatsdHost)
+	assert.Equal(t, port, abc.StatsdPort)
+
+	ctr, ok := ds.GetContainer("abc123")
+	assert.True(t, ok)
+	assert.Equal(t, fmt.Sprintf("[::1]:%d", port), ctr.Server.ServiceAddress)
+
+	// The server is bound to the IPv6 loopback specifically
+	_, err = net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback, Port: port})
+	assert.NotNil(t, err)
+}
+
+func TestAddContainerDefaultHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	// The default host is advertised, but need not be a local address
+	ds := DCOSStatsd{StatsdHost: "198.51.100.1", ContainersDir: dir}
+	addr := startTestServer(t, &ds)
+
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(`{"container_id":"abc123"}`)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	abc := parseContainer(t, resp.Body)
+	assert.Equal(t, "198.51.100.1", abc.StatsdHost)
+
+	// A container file written by an earlier version, which persisted the
+	// default host and no protocol
+	xyzjson := fmt.Sprintf(
+		`{"container_id":"xyz123","statsd_host":"198.51.100.1","statsd_port":%d}`,
+		findFreePort())
+	err = ioutil.WriteFile(dir+"/xyz123", []byte(xyzjson), 0666)
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not write container state: %s", err))
+	}
+	ds.Stop()
+
+	// On restart, both containers are bound to the wildcard address again
+	restarted := DCOSStatsd{StatsdHost: "198.51.100.1", ContainersDir: dir}
+	startTestServer(t, &restarted)
+	defer restarted.Stop()
+
+	for _, cid := range []string{"abc123", "xyz123"} {
+		ctr, ok := restarted.GetContainer(cid)
+		assert.True(t, ok)
+		assert.Equal(t, "198.51.100.1", ctr.StatsdHost)
+		assert.Equal(t, fmt.Sprintf(":%d", ctr.StatsdPort), ctr.Server.ServiceAddress)
+	}
+}
+
+func TestLoadContainerExplicitDefaultHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	// A container which explicitly requested the default host, written by
+	// the current version
+	port := findFreePort()
+	abcjson := fmt.Sprintf(
+		`{"container_id":"abc123","statsd_host":"127.0.0.1","statsd_port":%d,"statsd_protocol":"udp"}`,
+		port)
+	err = ioutil.WriteFile(dir+"/abc123", []byte(abcjson), 0666)
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not write container state: %s", err))
+	}
+
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", ContainersDir: dir}
+	startTestServer(t, &ds)
+	defer ds.Stop()
+
+	// It is bound to the host it requested, not the wildcard address
+	ctr, ok := ds.GetContainer("abc123")
+	assert.True(t, ok)
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", port), ctr.Server.ServiceAddress)
+}
+
+func TestAddContainerMetricSeparator(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	t.Log("A container with the default separator")
+	abcjson := `{"container_id":"abc123"}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	abc, ok := ds.GetContainer("abc123")
+	assert.True(t, ok)
+	assert.Equal(t, ".", abc.Server.MetricSeparator)
+
+	t.Log("A container with a custom separator")
+	xyzjson := `{"container_id":"xyz123","metric_separator":"_"}`
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(xyzjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	xyz := parseContainer(t, resp.Body)
+	assert.Equal(t, "_", xyz.MetricSeparator)
+	xyzctr, ok := ds.GetContainer("xyz123")
+	assert.True(t, ok)
+	assert.Equal(t, "_", xyzctr.Server.MetricSeparator)
+
+	t.Log("A container with an invalid separator")
+	qqqjson := `{"container_id":"qqq123","metric_separator":"::"}`
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(qqqjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	_, ok = ds.GetContainer("qqq123")
+	assert.False(t, ok)
+}
+
+func TestAddContainerParseDataDogTags(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	t.Log("A container with the default setting")
+	abcjson := `{"container_id":"abc123"}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	abc, ok := ds.GetContainer("abc123")
+	assert.True(t, ok)
+	assert.True(t, abc.Server.ParseDataDogTags)
+
+	t.Log("A container with parsing disabled")
+	xyzjson := `{"container_id":"xyz123","parse_datadog_tags":false}`
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(xyzjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	xyz := parseContainer(t, resp.Body)
+	if assert.NotNil(t, xyz.ParseDataDogTags) {
+		assert.False(t, *xyz.ParseDataDogTags)
+	}
+	xyzctr, ok := ds.GetContainer("xyz123")
+	assert.True(t, ok)
+	assert.False(t, xyzctr.Server.ParseDataDogTags)
+}
+
+func TestAuthToken(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", AuthToken: "s3cr3t"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	t.Run("Health is open", func(t *testing.T) {
+		resp, err := http.Get(addr + "/health")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		resp, err := http.Get(addr + "/containers")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		req, _ := http.NewRequest("POST", addr+"/container",
+			bytes.NewBuffer([]byte(`{"container_id":"abc123"}`)))
+		req.Header.Set("Authorization", "Bearer wrong")
+		resp, err = http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, 0, len(ds.containers))
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", addr+"/container",
+			bytes.NewBuffer([]byte(`{"container_id":"abc123"}`)))
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, 1, len(ds.containers))
+	})
+}
+
+func TestRemoveAllContainers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", ContainersDir: dir}
+
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	for _, cid := range []string{"abc123", "xyz123"} {
+		ctrjson := fmt.Sprintf(`{"container_id":%q}`, cid)
+		resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(ctrjson)))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	assert.Equal(t, 2, len(ds.containers))
+
+	resp, err := httpDelete(t, addr+"/containers")
+	assertResponseWas(t, resp, err, `{"removed":2}`)
+
+	// No containers remain in memory or on disk
+	assert.Equal(t, 0, len(ds.containers))
+	files, err := ioutil.ReadDir(ds.ContainersDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+
+	resp, err = http.Get(addr + "/containers")
+	assertResponseWas(t, resp, err, "[]")
+}
+
+func TestAddContainers(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1"}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	abcjson := `{"container_id":"abc123"}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	abc := parseContainer(t, resp.Body)
+
+	batch := `[{"container_id":"abc123"},{"container_id":"xyz123"}]`
+	resp, err = http.Post(addr+"/containers", "application/json", bytes.NewBuffer([]byte(batch)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var results []api.AddResult
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(results)) {
+		// The duplicate was skipped and points at the original
+		assert.Equal(t, "abc123", results[0].Id)
+		assert.Equal(t, http.StatusSeeOther, results[0].Status)
+		assert.Equal(t, abc.StatsdPort, results[0].Container.StatsdPort)
+
+		// The new container was added
+		assert.Equal(t, "xyz123", results[1].Id)
+		assert.Equal(t, http.StatusCreated, results[1].Status)
+		assert.NotEmpty(t, results[1].Container.StatsdPort)
+		assert.Empty(t, results[1].Error)
+	}
+
+	assert.Equal(t, 2, len(ds.containers))
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	abcjson := fmt.Sprintf(
+		`{"container_id":"abc123","statsd_host":"127.0.0.1","statsd_port":%d,"statsd_protocol":"udp"}`,
+		findFreePort())
+	err = ioutil.WriteFile(dir+"/abc123", []byte(abcjson), 0666)
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not write container state: %s", err))
+	}
+
+	ds := DCOSStatsd{ContainersDir: dir}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+	assert.Equal(t, 1, len(ds.ListContainers()))
+
+	// Write a second container out-of-band
+	xyzjson := fmt.Sprintf(
+		`{"container_id":"xyz123","statsd_host":"127.0.0.1","statsd_port":%d,"statsd_protocol":"udp"}`,
+		findFreePort())
+	err = ioutil.WriteFile(dir+"/xyz123", []byte(xyzjson), 0666)
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not write container state: %s", err))
+	}
+
+	resp, err := http.Post(addr+"/reload", "application/json", nil)
+	assertResponseWas(t, resp, err, `{"added":1,"skipped":1}`)
+
+	// The new container was added and the existing one left untouched
+	resp, err = http.Get(addr + "/container/xyz123")
+	assertResponseWas(t, resp, err, xyzjson)
+	resp, err = http.Get(addr + "/container/abc123")
+	assertResponseWas(t, resp, err, abcjson)
+	assert.Equal(t, 2, len(ds.ListContainers()))
+
+	// Reloading again adds nothing
+	resp, err = http.Post(addr+"/reload", "application/json", nil)
+	assertResponseWas(t, resp, err, `{"added":0,"skipped":2}`)
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", ContainersDir: dir}
+	startTestServer(t, &ds)
+	defer ds.Stop()
+
+	// Containers without a port would be started twice by overlapping reloads
+	for i := 0; i < 10; i++ {
+		ctrjson := fmt.Sprintf(`{"container_id":"ctr%d"}`, i)
+		err = ioutil.WriteFile(fmt.Sprintf("%s/ctr%d", dir, i), []byte(ctrjson), 0666)
+		if err != nil {
+			assert.Fail(t, fmt.Sprintf("Could not write container state: %s", err))
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	added := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			n, _, err := ds.Reload()
+			assert.Nil(t, err)
+			mu.Lock()
+			added += n
+			mu.Unlock()
+		}()
+		// Containers are added and removed while reloading
+		go func(i int) {
+			defer wg.Done()
+			cid := fmt.Sprintf("extra%d", i)
+			_, err := ds.AddContainer(containers.Container{Id: cid})
+			assert.Nil(t, err)
+			assert.Nil(t, ds.RemoveContainer(containers.Container{Id: cid}))
+		}(i)
+	}
+	wg.Wait()
+
+	// Each container was added by exactly one reload
+	assert.Equal(t, 10, added)
+	assert.Equal(t, 10, len(ds.ListContainers()))
+}
+
 // startTestServer starts a server on the specified DCOSStatsd on a randomly
 // selected port and returns the address on which it will be served. It also
 // runs a test against the /health endpoint to ensure that the command API is
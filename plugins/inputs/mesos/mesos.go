@@ -164,6 +164,11 @@ var sampleConfig = `
   ## Optional IAM configuration (DCOS)
   # ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
   # iam_config_path = "/run/dcos/etc/telegraf/master_service_account.json"
+  ## How long before it expires the IAM token is refreshed
+  # iam_token_refresh_window = "5m"
+  ## The minimum TLS version to accept when ca_certificate_path is set;
+  ## "1.2" or "1.3"
+  # tls_min_version = "1.2"
 `
 
 // SampleConfig returns a sample configuration block
@@ -1,6 +1,7 @@
 package prometheus
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -49,6 +51,15 @@ type Prometheus struct {
 
 	ResponseTimeout internal.Duration `toml:"response_timeout"`
 
+	// Maximum number of samples accepted from a single scrape; 0 is unlimited
+	SampleLimit int `toml:"sample_limit"`
+
+	// Request zstd-compressed responses from targets
+	AcceptZstd bool `toml:"accept_zstd"`
+
+	// Directory from which to read *.prom files on each gather
+	TextfileDirectory string `toml:"textfile_directory"`
+
 	tls.ClientConfig
 
 	client *http.Client
@@ -91,6 +102,11 @@ var sampleConfig = `
   ## Optional IAM configuration
   # ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
   # iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
+  ## How long before it expires the IAM token is refreshed
+  # iam_token_refresh_window = "5m"
+  ## The minimum TLS version to accept when ca_certificate_path is set;
+  ## "1.2" or "1.3"
+  # tls_min_version = "1.2"
 
   ## Use bearer token for authorization
   # bearer_token = /path/to/bearer/token
@@ -98,6 +114,18 @@ var sampleConfig = `
   ## Specify timeout duration for slower prometheus clients (default is 3s)
   # response_timeout = "3s"
 
+  ## Drop the whole scrape of a target which exposes more than this many
+  ## samples, recording up=0 instead. 0 disables the limit.
+  # sample_limit = 0
+
+  ## Advertise zstd in Accept-Encoding and decompress zstd responses; requires
+  ## Telegraf to be built with the zstd build tag
+  # accept_zstd = false
+
+  ## Read metrics from *.prom files in this directory on each gather, like
+  ## node_exporter's textfile collector
+  # textfile_directory = "/var/lib/telegraf/textfile"
+
   ## Optional TLS Config
   # tls_ca = /path/to/cafile
   # tls_cert = /path/to/certfile
@@ -142,6 +170,21 @@ type URLAndAddress struct {
 	Tags        map[string]string
 }
 
+// tags adds the url, address and any discovered tags of the target to the
+// given tag set
+func (u URLAndAddress) tags(tags map[string]string) map[string]string {
+	// strip user and password from URL
+	u.OriginalURL.User = nil
+	tags["url"] = u.OriginalURL.String()
+	if u.Address != "" {
+		tags["address"] = u.Address
+	}
+	for k, v := range u.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
 func (p *Prometheus) GetAllURLs() (map[string]URLAndAddress, error) {
 	allURLs := make(map[string]URLAndAddress, 0)
 	for _, u := range p.URLs {
@@ -211,6 +254,10 @@ func (p *Prometheus) GetAllURLs() (map[string]URLAndAddress, error) {
 // Reads stats from all configured servers accumulates stats.
 // Returns one of the errors encountered while gather stats (if any).
 func (p *Prometheus) Gather(acc telegraf.Accumulator) error {
+	if p.AcceptZstd && !zstdSupported {
+		return fmt.Errorf("accept_zstd: %s", errZstdNotSupported)
+	}
+
 	if p.client == nil {
 		client, err := p.createHTTPClient()
 		if err != nil {
@@ -221,9 +268,10 @@ func (p *Prometheus) Gather(acc telegraf.Accumulator) error {
 
 	var wg sync.WaitGroup
 
+	// Textfiles are gathered even if the URLs to scrape cannot be discovered
 	allURLs, err := p.GetAllURLs()
 	if err != nil {
-		return err
+		acc.AddError(err)
 	}
 	for _, URL := range allURLs {
 		wg.Add(1)
@@ -235,9 +283,44 @@ func (p *Prometheus) Gather(acc telegraf.Accumulator) error {
 
 	wg.Wait()
 
+	if p.TextfileDirectory != "" {
+		p.gatherTextfiles(acc)
+	}
+
 	return nil
 }
 
+// gatherTextfiles reads every *.prom file in the textfile directory, tagging
+// its metrics with the name of the file. A file which cannot be read or
+// parsed is reported as an error without affecting the other files.
+func (p *Prometheus) gatherTextfiles(acc telegraf.Accumulator) {
+	paths, err := filepath.Glob(filepath.Join(p.TextfileDirectory, "*.prom"))
+	if err != nil {
+		acc.AddError(fmt.Errorf("error listing textfiles in %s: %s", p.TextfileDirectory, err))
+		return
+	}
+
+	for _, path := range paths {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			acc.AddError(fmt.Errorf("error reading textfile %s: %s", path, err))
+			continue
+		}
+
+		metrics, err := Parse(body, http.Header{})
+		if err != nil {
+			acc.AddError(fmt.Errorf("error reading metrics for %s: %s", path, err))
+			continue
+		}
+
+		for _, metric := range metrics {
+			tags := metric.Tags()
+			tags["filename"] = filepath.Base(path)
+			addMetric(acc, metric, tags)
+		}
+	}
+}
+
 func (p *Prometheus) createHTTPClient() (*http.Client, error) {
 	tlsCfg, err := p.ClientConfig.TLSConfig()
 	if err != nil {
@@ -287,6 +370,11 @@ func (p *Prometheus) gatherURL(u URLAndAddress, acc telegraf.Accumulator) error
 	}
 
 	req.Header.Add("Accept", acceptHeader)
+	if p.AcceptZstd {
+		// Setting Accept-Encoding disables the transport's transparent gzip
+		// decompression, so readBody handles gzip as well
+		req.Header.Set("Accept-Encoding", "zstd, gzip")
+	}
 
 	var token []byte
 	if p.BearerToken != "" {
@@ -312,7 +400,7 @@ func (p *Prometheus) gatherURL(u URLAndAddress, acc telegraf.Accumulator) error
 		return fmt.Errorf("%s returned HTTP status %s", u.URL, resp.Status)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := readBody(resp)
 	if err != nil {
 		return fmt.Errorf("error reading body: %s", err)
 	}
@@ -323,35 +411,73 @@ func (p *Prometheus) gatherURL(u URLAndAddress, acc telegraf.Accumulator) error
 			u.URL, err)
 	}
 
-	for _, metric := range metrics {
-		tags := metric.Tags()
-		// strip user and password from URL
-		u.OriginalURL.User = nil
-		tags["url"] = u.OriginalURL.String()
-		if u.Address != "" {
-			tags["address"] = u.Address
-		}
-		for k, v := range u.Tags {
-			tags[k] = v
+	if p.SampleLimit > 0 {
+		if samples := countSamples(metrics); samples > p.SampleLimit {
+			tags := u.tags(map[string]string{"error": "sample_limit_exceeded"})
+			acc.AddGauge("up", map[string]interface{}{"gauge": float64(0)}, tags)
+			return fmt.Errorf("%s exposed %d samples, exceeding sample limit of %d",
+				u.URL, samples, p.SampleLimit)
 		}
+	}
 
-		switch metric.Type() {
-		case telegraf.Counter:
-			acc.AddCounter(metric.Name(), metric.Fields(), tags, metric.Time())
-		case telegraf.Gauge:
-			acc.AddGauge(metric.Name(), metric.Fields(), tags, metric.Time())
-		case telegraf.Summary:
-			acc.AddSummary(metric.Name(), metric.Fields(), tags, metric.Time())
-		case telegraf.Histogram:
-			acc.AddHistogram(metric.Name(), metric.Fields(), tags, metric.Time())
-		default:
-			acc.AddFields(metric.Name(), metric.Fields(), tags, metric.Time())
-		}
+	for _, metric := range metrics {
+		addMetric(acc, metric, u.tags(metric.Tags()))
 	}
 
 	return nil
 }
 
+// countSamples returns the number of samples in the exposition from which
+// metrics were parsed. Each field of a metric is one sample: a histogram or
+// summary has a field for each bucket or quantile, and for its sum and count.
+func countSamples(metrics []telegraf.Metric) int {
+	samples := 0
+	for _, metric := range metrics {
+		samples += len(metric.FieldList())
+	}
+	return samples
+}
+
+// addMetric adds a parsed metric to the accumulator with the given tags,
+// preserving its value type
+func addMetric(acc telegraf.Accumulator, metric telegraf.Metric, tags map[string]string) {
+	switch metric.Type() {
+	case telegraf.Counter:
+		acc.AddCounter(metric.Name(), metric.Fields(), tags, metric.Time())
+	case telegraf.Gauge:
+		acc.AddGauge(metric.Name(), metric.Fields(), tags, metric.Time())
+	case telegraf.Summary:
+		acc.AddSummary(metric.Name(), metric.Fields(), tags, metric.Time())
+	case telegraf.Histogram:
+		acc.AddHistogram(metric.Name(), metric.Fields(), tags, metric.Time())
+	default:
+		acc.AddFields(metric.Name(), metric.Fields(), tags, metric.Time())
+	}
+}
+
+// readBody reads the body of a response, decompressing it according to its
+// Content-Encoding
+func readBody(resp *http.Response) ([]byte, error) {
+	var r io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "zstd":
+		dec, err := newZstdReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ioutil.ReadAll(r)
+}
+
 // Start will start the Kubernetes scraping if enabled in the configuration
 func (p *Prometheus) Start(a telegraf.Accumulator) error {
 	if p.MonitorPods {
@@ -389,9 +515,7 @@ func (p *Prometheus) getMesosClient() (*httpcli.Client, error) {
 		if rt, err = p.DCOSConfig.Transport(); err != nil {
 			return nil, fmt.Errorf("error creating transport: %s", err)
 		}
-		if p.IAMConfigPath != "" {
-			cfgOpts = append(cfgOpts, httpcli.RoundTripper(rt))
-		}
+		cfgOpts = append(cfgOpts, httpcli.RoundTripper(rt))
 	}
 	opts = append(opts, httpcli.Do(httpcli.With(cfgOpts...)))
 	client.With(opts...)
@@ -2,9 +2,12 @@ package prometheus
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -147,3 +150,122 @@ func TestPrometheusGathersMesosMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestPrometheusSampleLimitExceeded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, sampleTextFormat)
+	}))
+	defer ts.Close()
+
+	p := &Prometheus{
+		URLs:        []string{ts.URL},
+		SampleLimit: 2,
+	}
+
+	var acc testutil.Accumulator
+
+	err := p.Gather(&acc)
+	require.NoError(t, err)
+	require.Len(t, acc.Errors, 1)
+
+	assert.False(t, acc.HasMeasurement("go_gc_duration_seconds"))
+	assert.False(t, acc.HasMeasurement("go_goroutines"))
+	assert.False(t, acc.HasMeasurement("test_metric"))
+	acc.AssertContainsTaggedFields(t, "up",
+		map[string]interface{}{"gauge": float64(0)},
+		map[string]string{
+			"url":   ts.URL + "/metrics",
+			"error": "sample_limit_exceeded",
+		})
+}
+
+func TestPrometheusSampleLimitCountsSamples(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, sampleTextFormat)
+	}))
+	defer ts.Close()
+
+	// sampleTextFormat has three metrics, but nine samples: the summary's
+	// five quantiles, sum and count, the gauge and the untyped metric
+	p := &Prometheus{
+		URLs:        []string{ts.URL},
+		SampleLimit: 8,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, p.Gather(&acc))
+	require.Len(t, acc.Errors, 1)
+	assert.False(t, acc.HasMeasurement("go_gc_duration_seconds"))
+
+	p = &Prometheus{
+		URLs:        []string{ts.URL},
+		SampleLimit: 9,
+	}
+	var acc2 testutil.Accumulator
+	require.NoError(t, acc2.GatherError(p.Gather))
+	assert.True(t, acc2.HasFloatField("go_gc_duration_seconds", "count"))
+}
+
+func TestPrometheusGeneratesMetricsFromTextfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"backup.prom":  "# TYPE backup_last_success_seconds gauge\nbackup_last_success_seconds 1490802350\n",
+		"cron.prom":    "# TYPE cron_jobs_total counter\ncron_jobs_total{job=\"cleanup\"} 3\n",
+		"corrupt.prom": "this is not the text format\n",
+		"ignored.txt":  sampleTextFormat,
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		require.NoError(t, err)
+	}
+
+	p := &Prometheus{
+		TextfileDirectory: dir,
+	}
+
+	var acc testutil.Accumulator
+
+	// a corrupt file is reported but does not fail the gather
+	err = p.Gather(&acc)
+	require.NoError(t, err)
+	assert.Len(t, acc.Errors, 1)
+
+	acc.AssertContainsTaggedFields(t, "backup_last_success_seconds",
+		map[string]interface{}{"gauge": float64(1490802350)},
+		map[string]string{"filename": "backup.prom"})
+	acc.AssertContainsTaggedFields(t, "cron_jobs_total",
+		map[string]interface{}{"counter": float64(3)},
+		map[string]string{"filename": "cron.prom", "job": "cleanup"})
+	assert.False(t, acc.HasMeasurement("go_goroutines"))
+}
+
+func TestPrometheusGathersTextfilesWhenDiscoveryFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "backup.prom"),
+		[]byte("# TYPE backup_last_success_seconds gauge\nbackup_last_success_seconds 1490802350\n"), 0644)
+	require.NoError(t, err)
+
+	// The mesos agent is unreachable
+	agent := httptest.NewServer(http.NotFoundHandler())
+	agent.Close()
+
+	p := &Prometheus{
+		MesosAgentUrl:     agent.URL,
+		MesosTimeout:      internal.Duration{Duration: time.Second},
+		TextfileDirectory: dir,
+	}
+
+	var acc testutil.Accumulator
+
+	// The discovery error is reported, and textfiles are gathered regardless
+	err = p.Gather(&acc)
+	require.NoError(t, err)
+	assert.Len(t, acc.Errors, 1)
+	acc.AssertContainsTaggedFields(t, "backup_last_success_seconds",
+		map[string]interface{}{"gauge": float64(1490802350)},
+		map[string]string{"filename": "backup.prom"})
+}
@@ -0,0 +1,21 @@
+// +build zstd
+
+package prometheus
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdSupported is true when Telegraf is built with the zstd build tag
+const zstdSupported = true
+
+// newZstdReader returns a reader which decompresses the zstd stream r
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
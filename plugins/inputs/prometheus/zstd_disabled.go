@@ -0,0 +1,20 @@
+// +build !zstd
+
+package prometheus
+
+import (
+	"errors"
+	"io"
+)
+
+// zstdSupported is true when Telegraf is built with the zstd build tag
+const zstdSupported = false
+
+// errZstdNotSupported is returned when zstd is used by a Telegraf which was
+// built without the zstd build tag
+var errZstdNotSupported = errors.New("zstd support requires Telegraf to be built with `-tags zstd`")
+
+// newZstdReader always fails, since zstd support was not built in
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	return nil, errZstdNotSupported
+}
@@ -0,0 +1,20 @@
+// +build !zstd
+
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusAcceptZstdNotSupported(t *testing.T) {
+	p := &Prometheus{
+		URLs:       []string{"http://localhost:9273/metrics"},
+		AcceptZstd: true,
+	}
+
+	var acc testutil.Accumulator
+	require.Error(t, p.Gather(&acc))
+}
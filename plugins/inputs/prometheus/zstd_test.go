@@ -0,0 +1,51 @@
+// +build zstd
+
+// zstd support, and therefore its tests, are only built with the zstd build
+// tag. They can be invoked via `go test -tags zstd .`
+
+package prometheus
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusGeneratesMetricsFromZstd(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	payload := enc.EncodeAll([]byte(sampleTextFormat), nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "zstd") {
+			fmt.Fprintln(w, sampleTextFormat)
+			return
+		}
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Write(payload)
+	}))
+	defer ts.Close()
+
+	p := &Prometheus{
+		URLs:       []string{ts.URL},
+		AcceptZstd: true,
+	}
+
+	var acc testutil.Accumulator
+
+	err = acc.GatherError(p.Gather)
+	require.NoError(t, err)
+
+	assert.True(t, acc.HasFloatField("go_gc_duration_seconds", "count"))
+	assert.True(t, acc.HasFloatField("go_goroutines", "gauge"))
+	assert.True(t, acc.HasFloatField("test_metric", "value"))
+	assert.True(t, acc.HasTimestamp("test_metric", time.Unix(1490802350, 0)))
+}
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdata/telegraf/plugins/parsers/graphite"
@@ -43,7 +44,7 @@ var malformedwarn = "E! Statsd over TCP has received %d malformed packets" +
 	" thus far."
 
 type Statsd struct {
-	// Protocol used on listener - udp or tcp
+	// Protocol used on listener - udp, tcp or unixgram
 	Protocol string `toml:"protocol"`
 
 	// Address & Port to serve from
@@ -80,6 +81,11 @@ type Statsd struct {
 
 	// Channel for reporting the listen address for the statsd server after it starts.
 	ListenAddr chan net.Addr
+	// listening is set to 1 while the listener is accepting packets
+	listening int32
+	// lastActivity is the time, in unix nanoseconds, at which data was last
+	// received, or at which the server was started
+	lastActivity int64
 
 	sync.Mutex
 	// Lock for preventing a data race during resource cleanup
@@ -109,9 +115,12 @@ type Statsd struct {
 	// bucket -> influx templates
 	Templates []string
 
-	// Protocol listeners
-	UDPlistener *net.UDPConn
-	TCPlistener *net.TCPListener
+	// Protocol listeners. A UDP or unixgram listener which is already bound
+	// when Start is called is used instead of binding ServiceAddress, which
+	// allows the caller to handle a failure to bind.
+	UDPlistener      *net.UDPConn
+	TCPlistener      *net.TCPListener
+	UnixgramListener *net.UnixConn
 
 	// track current connections so we can close them in Stop()
 	conns map[string]*net.TCPConn
@@ -180,7 +189,8 @@ func (_ *Statsd) Description() string {
 }
 
 const sampleConfig = `
-  ## Protocol, must be "tcp", "udp", "udp4" or "udp6" (default=udp)
+  ## Protocol, must be "tcp", "udp", "udp4", "udp6" or "unixgram" (default=udp)
+  ## For unixgram, service_address is the path of the socket to listen on.
   protocol = "udp"
 
   ## MaxTCPConnection - applicable when protocol is set to tcp (default=250)
@@ -307,6 +317,7 @@ func (s *Statsd) Start(_ telegraf.Accumulator) error {
 	s.sets = make(map[string]cachedset)
 	s.timings = make(map[string]cachedtimings)
 	s.ListenAddr = make(chan net.Addr, 1)
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
 
 	s.Lock()
 	defer s.Unlock()
@@ -348,6 +359,8 @@ func (s *Statsd) Start(_ telegraf.Accumulator) error {
 	// Start the UDP listener
 	if s.isUDP() {
 		go s.udpListen()
+	} else if s.isUnixgram() {
+		go s.unixgramListen()
 	} else {
 		go s.tcpListen()
 	}
@@ -369,6 +382,9 @@ func (s *Statsd) tcpListen() error {
 		return err
 	}
 
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
+
 	addr := s.TCPlistener.Addr()
 	log.Println("I! TCP Statsd listening on: ", addr.String())
 	s.ListenAddr <- addr
@@ -415,13 +431,18 @@ func (s *Statsd) tcpListen() error {
 // udpListen starts listening for udp packets on the configured port.
 func (s *Statsd) udpListen() error {
 	defer s.wg.Done()
-	var err error
-	address, _ := net.ResolveUDPAddr(s.Protocol, s.ServiceAddress)
-	s.UDPlistener, err = net.ListenUDP(s.Protocol, address)
-	if err != nil {
-		log.Fatalf("ERROR: ListenUDP - %s", err)
+	if s.UDPlistener == nil {
+		var err error
+		address, _ := net.ResolveUDPAddr(s.Protocol, s.ServiceAddress)
+		s.UDPlistener, err = net.ListenUDP(s.Protocol, address)
+		if err != nil {
+			log.Fatalf("ERROR: ListenUDP - %s", err)
+		}
 	}
 
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
+
 	addr := s.UDPlistener.LocalAddr()
 	log.Println("I! Statsd UDP listener listening on: ", addr.String())
 	s.ListenAddr <- addr
@@ -454,6 +475,53 @@ func (s *Statsd) udpListen() error {
 	}
 }
 
+// unixgramListen starts listening for datagrams on the configured unix socket.
+func (s *Statsd) unixgramListen() error {
+	defer s.wg.Done()
+	if s.UnixgramListener == nil {
+		var err error
+		address := &net.UnixAddr{Name: s.ServiceAddress, Net: "unixgram"}
+		s.UnixgramListener, err = net.ListenUnixgram("unixgram", address)
+		if err != nil {
+			log.Fatalf("ERROR: ListenUnixgram - %s", err)
+		}
+	}
+
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
+
+	addr := s.UnixgramListener.LocalAddr()
+	log.Println("I! Statsd unixgram listener listening on: ", addr.String())
+	s.ListenAddr <- addr
+
+	if s.ReadBufferSize > 0 {
+		s.UnixgramListener.SetReadBuffer(s.ReadBufferSize)
+	}
+
+	buf := make([]byte, UDP_MAX_PACKET_SIZE)
+	for {
+		select {
+		case <-s.done:
+			return nil
+		default:
+			n, _, err := s.UnixgramListener.ReadFromUnix(buf)
+			if err != nil && !strings.Contains(err.Error(), "closed network") {
+				log.Printf("E! Error READ: %s\n", err.Error())
+				continue
+			}
+			b := s.bufPool.Get().(*bytes.Buffer)
+			b.Reset()
+			b.Write(buf[:n])
+
+			select {
+			case s.in <- b:
+			default:
+				s.reportDroppedMessage()
+			}
+		}
+	}
+}
+
 // parser monitors the s.in channel, if there is a packet ready, it parses the
 // packet into statsd strings and then calls parseStatsdLine, which parses a
 // single statsd metric into a struct.
@@ -464,6 +532,7 @@ func (s *Statsd) parser() error {
 		case <-s.done:
 			return nil
 		case buf := <-s.in:
+			atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
 			lines := strings.Split(buf.String(), "\n")
 			s.bufPool.Put(buf)
 			for _, line := range lines {
@@ -874,6 +943,8 @@ func (s *Statsd) Stop() {
 	close(s.done)
 	if s.isUDP() {
 		s.UDPlistener.Close()
+	} else if s.isUnixgram() {
+		s.UnixgramListener.Close()
 	} else {
 		s.TCPlistener.Close()
 		// Close all open TCP connections
@@ -900,11 +971,27 @@ func (s *Statsd) Stop() {
 	s.Unlock()
 }
 
+// Listening returns true while the server's listener is accepting packets.
+func (s *Statsd) Listening() bool {
+	return atomic.LoadInt32(&s.listening) == 1
+}
+
+// LastActivity returns the time at which the server last received data, or
+// the time at which it was started if it has received none.
+func (s *Statsd) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastActivity))
+}
+
 // IsUDP returns true if the protocol is UDP, false otherwise.
 func (s *Statsd) isUDP() bool {
 	return strings.HasPrefix(s.Protocol, "udp")
 }
 
+// isUnixgram returns true if the protocol is unixgram, false otherwise.
+func (s *Statsd) isUnixgram() bool {
+	return s.Protocol == "unixgram"
+}
+
 func init() {
 	inputs.Add("statsd", func() telegraf.Input {
 		return &Statsd{
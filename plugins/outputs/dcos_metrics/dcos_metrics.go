@@ -4,7 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/dcos/dcos-metrics/producers"
 	httpProducer "github.com/dcos/dcos-metrics/producers/http"
@@ -24,8 +29,33 @@ type DCOSMetrics struct {
 	DCOSClusterID     string            `toml:"dcos_cluster_id"`
 	DCOSNodePrivateIP string            `toml:"dcos_node_private_ip"`
 
+	NodeMetricPrefix      string `toml:"node_metric_prefix"`
+	ContainerMetricPrefix string `toml:"container_metric_prefix"`
+	AppMetricPrefix       string `toml:"app_metric_prefix"`
+
+	// ReadyOnFirstMetric serves a /ready endpoint which reports ready only
+	// once the first metric has been enqueued.
+	ReadyOnFirstMetric bool `toml:"ready_on_first_metric"`
+	// UnavailableUntilReady causes metric endpoints to return 503 until ready.
+	UnavailableUntilReady bool `toml:"unavailable_until_ready"`
+
 	translator producerTranslator
 	metricChan chan producers.MetricsMessage
+	ready      *readiness
+	server     *http.Server
+}
+
+// readiness records whether the first metric has been enqueued
+type readiness struct {
+	ready int32
+}
+
+func (r *readiness) set() {
+	atomic.StoreInt32(&r.ready, 1)
+}
+
+func (r *readiness) isReady() bool {
+	return atomic.LoadInt32(&r.ready) == 1
 }
 
 func (d *DCOSMetrics) Description() string {
@@ -54,15 +84,37 @@ func (d *DCOSMetrics) SampleConfig() string {
 
   # Global DC/OS Cluster ID.
   dcos_cluster_id = "4321FEDCBA"
+
+  # Override the names of node, container and app metrics messages.
+  # Defaults to dcos.metrics.node, dcos.metrics.container and dcos.metrics.app.
+  #node_metric_prefix = "dcos.metrics.node"
+  #container_metric_prefix = "dcos.metrics.container"
+  #app_metric_prefix = "dcos.metrics.app"
+
+  # Serve a /ready endpoint which returns 503 until the first metric has been
+  # received, then 200.
+  #ready_on_first_metric = false
+
+  # Also return 503 from metric endpoints until the first metric has been
+  # received. Requires ready_on_first_metric.
+  #unavailable_until_ready = false
 `
 }
 
 func (d *DCOSMetrics) Connect() error {
+	if d.UnavailableUntilReady && !d.ReadyOnFirstMetric {
+		return errors.New("unavailable_until_ready requires ready_on_first_metric")
+	}
+
 	d.translator = producerTranslator{
 		MesosID:           d.MesosID,
 		DCOSNodeRole:      d.DCOSNodeRole,
 		DCOSClusterID:     d.DCOSClusterID,
 		DCOSNodePrivateIP: d.DCOSNodePrivateIP,
+
+		NodeMetricPrefix:      d.NodeMetricPrefix,
+		ContainerMetricPrefix: d.ContainerMetricPrefix,
+		AppMetricPrefix:       d.AppMetricPrefix,
 	}
 
 	config, err := d.producerConfig()
@@ -70,6 +122,12 @@ func (d *DCOSMetrics) Connect() error {
 		return err
 	}
 
+	if d.ReadyOnFirstMetric {
+		if err := d.serveReadiness(&config); err != nil {
+			return err
+		}
+	}
+
 	producer, producerChan := httpProducer.New(config)
 	d.metricChan = producerChan
 	go producer.Run()
@@ -77,8 +135,14 @@ func (d *DCOSMetrics) Connect() error {
 	return nil
 }
 
-// dcos-metrics producers don't offer a mechanism to stop them, and there's nothing to clean up.
-func (d *DCOSMetrics) Close() error { return nil }
+// dcos-metrics producers don't offer a mechanism to stop them, so we only
+// stop the readiness server, if there is one.
+func (d *DCOSMetrics) Close() error {
+	if d.server != nil {
+		return d.server.Close()
+	}
+	return nil
+}
 
 func (d *DCOSMetrics) Write(metrics []telegraf.Metric) error {
 	for _, metric := range metrics {
@@ -88,11 +152,62 @@ func (d *DCOSMetrics) Write(metrics []telegraf.Metric) error {
 		}
 		if ok {
 			d.metricChan <- message
+			if d.ready != nil {
+				d.ready.set()
+			}
 		}
 	}
 	return nil
 }
 
+// serveReadiness serves the readiness endpoint on the configured address,
+// proxying all other requests to the producer, which is moved to a private
+// address on the loopback interface.
+func (d *DCOSMetrics) serveReadiness(config *httpProducer.Config) error {
+	public := config.Listener
+	if public == nil {
+		l, err := net.Listen("tcp", net.JoinHostPort(config.IP, strconv.Itoa(config.Port)))
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %s", d.Listen, err)
+		}
+		public = l
+	}
+
+	private, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("error listening for producer: %s", err)
+	}
+	config.Listener = private
+
+	d.ready = &readiness{}
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: private.Addr().String()})
+	d.server = &http.Server{Handler: readinessHandler(d.ready, d.UnavailableUntilReady, proxy)}
+	go d.server.Serve(public)
+
+	return nil
+}
+
+// readinessHandler serves /ready, returning 503 until the first metric has
+// been enqueued. If unavailable is true, metric endpoints also return 503
+// until then. All other requests are passed to next.
+func readinessHandler(ready *readiness, unavailable bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" {
+			if !ready.isReady() {
+				http.Error(w, "no metrics have been received", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if unavailable && !ready.isReady() && strings.HasPrefix(r.URL.Path, "/v0/") {
+			http.Error(w, "no metrics have been received", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // producerConfig returns a httpProducer.Config configured from d.
 func (d *DCOSMetrics) producerConfig() (httpProducer.Config, error) {
 	var (
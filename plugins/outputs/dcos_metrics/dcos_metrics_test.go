@@ -217,6 +217,81 @@ func TestDCOSMetricsNilValue(t *testing.T) {
 	}
 }
 
+func TestDCOSMetricsUnavailableUntilReadyRequiresReady(t *testing.T) {
+	dcosMetrics := DCOSMetrics{
+		Listen:                fmt.Sprintf("localhost:%d", findFreePort()),
+		UnavailableUntilReady: true,
+	}
+	if err := dcosMetrics.Connect(); err == nil {
+		t.Fatal("Expected unavailable_until_ready without ready_on_first_metric to be rejected")
+	}
+}
+
+func TestDCOSMetricsReadyOnFirstMetric(t *testing.T) {
+	// Assert that the readiness and metric endpoints return 503 until the first metric is written
+	serverHostPort := fmt.Sprintf("localhost:%d", findFreePort())
+	url := fmt.Sprintf("http://%s", serverHostPort)
+
+	dcosMetrics := DCOSMetrics{
+		Listen:                serverHostPort,
+		CacheExpiry:           internal.Duration{Duration: time.Second},
+		MesosID:               "fake-mesos-id",
+		DCOSNodeRole:          "agent",
+		DCOSClusterID:         "fake-cluster-id",
+		DCOSNodePrivateIP:     "10.0.0.1",
+		ReadyOnFirstMetric:    true,
+		UnavailableUntilReady: true,
+	}
+	if err := dcosMetrics.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer dcosMetrics.Close()
+
+	// The health check is passed through to the producer regardless
+	err := waitFor(func() bool {
+		resp, err := http.Get(url + "/health")
+		return err == nil && resp.StatusCode == 200
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/ready", "/v0/node"} {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 503 {
+			t.Fatalf("expected status code 503 for %s before the first write, got %d", path, resp.StatusCode)
+		}
+	}
+
+	m, err := metric.New(
+		"dcos.metrics.node.system",
+		map[string]string{},
+		map[string]interface{}{"uptime": uint64(12345)},
+		time.Now(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dcosMetrics.Write([]telegraf.Metric{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/ready", "/v0/node"} {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status code 200 for %s after the first write, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
 func setupDCOSMetrics() (DCOSMetrics, string, error) {
 	serverHostPort := fmt.Sprintf("localhost:%d", findFreePort())
 	serverURL := fmt.Sprintf("http://%s", serverHostPort)
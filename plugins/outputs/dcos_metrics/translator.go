@@ -19,6 +19,11 @@ type producerTranslator struct {
 	DCOSNodeRole      string
 	DCOSClusterID     string
 	DCOSNodePrivateIP string
+
+	// Message names; the producers' library constants are used if unset
+	NodeMetricPrefix      string
+	ContainerMetricPrefix string
+	AppMetricPrefix       string
 }
 
 // metricMapping describes the relationship between a telegraf metric name and
@@ -103,7 +108,7 @@ func (t *producerTranslator) containerMetricsMessage(m telegraf.Metric) producer
 	}
 
 	return producers.MetricsMessage{
-		Name:       producers.ContainerMetricPrefix,
+		Name:       t.containerMetricName(),
 		Datapoints: datapointsFromMetric(m, dpTags),
 		Dimensions: producers.Dimensions{
 			MesosID:       t.MesosID,
@@ -128,7 +133,7 @@ func (t *producerTranslator) appMetricsMessage(m telegraf.Metric) producers.Metr
 	delete(tags, "metric_type")
 
 	return producers.MetricsMessage{
-		Name:       producers.AppMetricPrefix,
+		Name:       t.appMetricName(),
 		Datapoints: datapointsFromMetric(m, tags),
 		Dimensions: producers.Dimensions{
 			MesosID:       t.MesosID,
@@ -155,7 +160,7 @@ func (t *producerTranslator) cpuMetricsMessage(m telegraf.Metric) (producers.Met
 	usage_total := 100.0 - usage_idle
 
 	return producers.MetricsMessage{
-		Name: producers.NodeMetricPrefix,
+		Name: t.nodeMetricName(),
 		Datapoints: []producers.Datapoint{
 			// Number of CPU cores isn't available. See https://github.com/influxdata/telegraf/issues/2020.
 			{
@@ -203,7 +208,7 @@ func (t *producerTranslator) diskMetricsMessage(m telegraf.Metric) producers.Met
 	timestamp := timestampFromMetric(m)
 	tags := map[string]string{"path": m.Tags()["path"]}
 	return producers.MetricsMessage{
-		Name: producers.NodeMetricPrefix,
+		Name: t.nodeMetricName(),
 		Datapoints: []producers.Datapoint{
 			{
 				Name:      "filesystem.capacity.total",
@@ -261,7 +266,7 @@ func (t *producerTranslator) memMetricsMessage(m telegraf.Metric) producers.Metr
 	fields := m.Fields()
 	timestamp := timestampFromMetric(m)
 	return producers.MetricsMessage{
-		Name: producers.NodeMetricPrefix,
+		Name: t.nodeMetricName(),
 		Datapoints: []producers.Datapoint{
 			{
 				Name:      "memory.total",
@@ -301,7 +306,7 @@ func (t *producerTranslator) swapMetricsMessage(m telegraf.Metric) producers.Met
 	fields := m.Fields()
 	timestamp := timestampFromMetric(m)
 	return producers.MetricsMessage{
-		Name: producers.NodeMetricPrefix,
+		Name: t.nodeMetricName(),
 		Datapoints: []producers.Datapoint{
 			{
 				Name:      "swap.total",
@@ -361,7 +366,7 @@ func (t *producerTranslator) netMetricsMessage(m telegraf.Metric) producers.Metr
 	}
 
 	return producers.MetricsMessage{
-		Name:       producers.NodeMetricPrefix,
+		Name:       t.nodeMetricName(),
 		Datapoints: datapoints,
 		Dimensions: producers.Dimensions{
 			MesosID:   t.MesosID,
@@ -374,7 +379,7 @@ func (t *producerTranslator) netMetricsMessage(m telegraf.Metric) producers.Metr
 // processesMetricsMessage returns a producers.MetricsMessage built from the processes metric m.
 func (t *producerTranslator) processesMetricsMessage(m telegraf.Metric) producers.MetricsMessage {
 	return producers.MetricsMessage{
-		Name: producers.NodeMetricPrefix,
+		Name: t.nodeMetricName(),
 		Datapoints: []producers.Datapoint{
 			{
 				Name:      "process.count",
@@ -416,7 +421,7 @@ func (t *producerTranslator) systemMetricsMessage(m telegraf.Metric) producers.M
 	}
 
 	return producers.MetricsMessage{
-		Name:       producers.NodeMetricPrefix,
+		Name:       t.nodeMetricName(),
 		Datapoints: datapoints,
 		Dimensions: producers.Dimensions{
 			MesosID:   t.MesosID,
@@ -426,6 +431,30 @@ func (t *producerTranslator) systemMetricsMessage(m telegraf.Metric) producers.M
 	}
 }
 
+// nodeMetricName returns the name of node metrics messages.
+func (t *producerTranslator) nodeMetricName() string {
+	if t.NodeMetricPrefix != "" {
+		return t.NodeMetricPrefix
+	}
+	return producers.NodeMetricPrefix
+}
+
+// containerMetricName returns the name of container metrics messages.
+func (t *producerTranslator) containerMetricName() string {
+	if t.ContainerMetricPrefix != "" {
+		return t.ContainerMetricPrefix
+	}
+	return producers.ContainerMetricPrefix
+}
+
+// appMetricName returns the name of app metrics messages.
+func (t *producerTranslator) appMetricName() string {
+	if t.AppMetricPrefix != "" {
+		return t.AppMetricPrefix
+	}
+	return producers.AppMetricPrefix
+}
+
 // datapointsFromMetric returns a []producers.Datapoint for the fields in m, with tags set on all Datapoints.
 // Datapoints are sorted by name for stability.
 func datapointsFromMetric(m telegraf.Metric, tags map[string]string) []producers.Datapoint {
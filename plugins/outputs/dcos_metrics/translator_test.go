@@ -778,6 +778,74 @@ func TestTranslate(t *testing.T) {
 	}
 }
 
+func TestTranslateOverriddenPrefixes(t *testing.T) {
+	tr := producerTranslator{
+		MesosID:               "mesos_id",
+		DCOSNodeRole:          "master",
+		DCOSClusterID:         "cluster_id",
+		DCOSNodePrivateIP:     "10.0.0.1",
+		NodeMetricPrefix:      "custom.node",
+		ContainerMetricPrefix: "custom.container",
+		AppMetricPrefix:       "custom.app",
+	}
+
+	type testCase struct {
+		name  string
+		input metricParams
+		want  string
+	}
+
+	testCases := []testCase{
+		{
+			name: "node metric",
+			input: metricParams{
+				name:   "prefix.processes",
+				fields: map[string]interface{}{"total": uint64(100)},
+				tm:     tm,
+				tp:     telegraf.Gauge,
+			},
+			want: "custom.node",
+		},
+		{
+			name: "container metric",
+			input: metricParams{
+				name:   "prefix.cpus",
+				tags:   map[string]string{"container_id": "cid"},
+				fields: map[string]interface{}{"limit": 1.0},
+				tm:     tm,
+				tp:     telegraf.Untyped,
+			},
+			want: "custom.container",
+		},
+		{
+			name: "app metric",
+			input: metricParams{
+				name:   "prefix.foo",
+				tags:   map[string]string{"container_id": "cid", "metric_type": "gauge"},
+				fields: map[string]interface{}{"value": 1.0},
+				tm:     tm,
+				tp:     telegraf.Gauge,
+			},
+			want: "custom.app",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, ok, err := tr.Translate(tc.input.NewMetric(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatal("translation failed to produce a MetricsMessage")
+			}
+			if msg.Name != tc.want {
+				t.Fatalf("expected message name %s, got %s", tc.want, msg.Name)
+			}
+		})
+	}
+}
+
 func TestTranslateFail(t *testing.T) {
 	type testCase struct {
 		name  string
@@ -24,14 +24,23 @@ import (
 
 type DCOSMetadata struct {
 	MesosAgentUrl              string
+	MesosAgentUrls             []string
 	Timeout                    internal.Duration
 	RateLimit                  internal.Duration
+	CacheExpiry                internal.Duration
+	NegativeCacheTTL           internal.Duration
 	Whitelist, WhitelistPrefix []string
 	UserAgent                  string
+	DropUnenriched             bool
+	TagNames                   map[string]string
+	tagNames                   map[string]string
 	containers                 map[string]containerInfo
-	mu                         sync.Mutex
+	unknown                    map[string]time.Time
+	failures                   int
+	refreshDelay               time.Duration
+	mu                         sync.RWMutex
 	once                       Once
-	client                     *httpcli.Client
+	clients                    map[string]*httpcli.Client
 	dcosutil.DCOSConfig
 }
 
@@ -39,30 +48,70 @@ type DCOSMetadata struct {
 // information about the task, executor and framework.
 type containerInfo struct {
 	containerID   string
+	taskID        string
 	taskName      string
+	taskState     string
 	executorName  string
 	frameworkName string
+	agentID       string
 	taskLabels    map[string]string
+	// fetchedAt is the time at which this info was retrieved from mesos
+	fetchedAt time.Time
 }
 
+// defaultTagNames are the keys of the tags which are added to metrics, each of
+// which may be renamed with the tag_names option
+var defaultTagNames = []string{"service_name", "task_name", "task_id", "task_state", "executor_name", "agent_id"}
+
+// minRefreshBackoff is the period for which refreshes are suspended after a
+// first failure when rate_limit is 0
+const minRefreshBackoff = time.Second
+
+// maxRefreshBackoff is the longest period for which refreshes are suspended
+// after consecutive failures, unless rate_limit is longer
+const maxRefreshBackoff = 5 * time.Minute
+
 const sampleConfig = `
 	## The URL of the local mesos agent
 	mesos_agent_url = "http://$NODE_PRIVATE_IP:5051"
+	## The URLs of several mesos agents, whose state is merged; if set, this
+	## takes precedence over mesos_agent_url
+	# mesos_agent_urls = ["http://198.51.100.1:5051", "http://198.51.100.2:5051"]
 	## The period after which requests to mesos agent should time out
 	timeout = "10s"
 	## The minimum period between requests to the mesos agent
 	rate_limit = "5s"
+	## The period after which cached container metadata is fetched again
+	## from the mesos agent; it is still used until it has been replaced
+	cache_expiry = "5m"
+	## The period for which a container ID which could not be found in mesos
+	## state is not looked for again; 0 disables negative caching
+	negative_cache_ttl = "1m"
 	## List of labels to always add to each metric as tags
 	whitelist = []
 	## List of prefixes a label should have in order to be added
 	## to each metric as tags; the prefix is stripped from the
 	## label when tagging
 	whitelist_prefix = []
+	## Drop metrics whose container_id could not be found in mesos state,
+	## rather than passing them on without metadata
+	drop_unenriched = false
   	## The user agent to send with requests
 	user_agent = "Telegraf-dcos-metadata"
 	## Optional IAM configuration
 	# ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
 	# iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
+	## How long before it expires the IAM token is refreshed
+	# iam_token_refresh_window = "5m"
+	## The minimum TLS version to accept when ca_certificate_path is set;
+	## "1.2" or "1.3"
+	# tls_min_version = "1.2"
+	## Rename the tags which are added to each metric; tags which are not
+	## listed keep their default names
+	# [processors.dcos_metadata.tag_names]
+	#   service_name = "framework"
+	#   task_name = "task"
+	#   executor_name = "executor"
 `
 
 // SampleConfig returns the default configuration
@@ -75,6 +124,17 @@ func (dm *DCOSMetadata) Description() string {
 	return "Plugin for adding metadata to dcos-specific metrics"
 }
 
+// Init validates the configuration. It is called once the configuration has
+// been loaded.
+func (dm *DCOSMetadata) Init() error {
+	tagNames, err := buildTagNames(dm.TagNames)
+	if err != nil {
+		return err
+	}
+	dm.tagNames = tagNames
+	return nil
+}
+
 // Apply the filter to the given metrics
 func (dm *DCOSMetadata) Apply(in ...telegraf.Metric) []telegraf.Metric {
 	// stale tracks whether our container cache is stale
@@ -83,24 +143,64 @@ func (dm *DCOSMetadata) Apply(in ...telegraf.Metric) []telegraf.Metric {
 	// track unrecognised container ids
 	nonCachedIDs := map[string]bool{}
 
+	tagNames := dm.tagNames
+	if tagNames == nil {
+		// Init has not been called, so tag_names has not been validated
+		tagNames, _ = buildTagNames(nil)
+	}
+
+	// cache replaces the map rather than mutating it, so it is safe to read
+	// from a snapshot of it while a refresh is in progress
+	dm.mu.RLock()
+	containers := dm.containers
+	unknown := dm.unknown
+	dm.mu.RUnlock()
+
+	// expired metadata continues to be applied until a refresh replaces it,
+	// so that metrics are not left unenriched while the refresh is in flight
+	// or if it fails
+	if dm.hasExpired(containers) {
+		stale = true
+	}
+
+	// the cache is warm once it has been populated; until then, every
+	// container is unrecognised
+	warm := containers != nil
+
+	out := []telegraf.Metric{}
 	for _, metric := range in {
 		// Ignore metrics without container_id tag
 		if cid, ok := metric.Tags()["container_id"]; ok {
-			if c, ok := dm.containers[cid]; ok {
+			if c, ok := containers[cid]; ok {
 				// Data for this container was cached
 				for k, v := range c.taskLabels {
 					metric.AddTag(k, v)
 				}
-				metric.AddTag("service_name", c.frameworkName)
+				metric.AddTag(tagNames["service_name"], c.frameworkName)
 				if c.executorName != "" {
-					metric.AddTag("executor_name", c.executorName)
+					metric.AddTag(tagNames["executor_name"], c.executorName)
+				}
+				metric.AddTag(tagNames["task_name"], c.taskName)
+				if c.taskID != "" {
+					metric.AddTag(tagNames["task_id"], c.taskID)
+				}
+				if c.taskState != "" {
+					metric.AddTag(tagNames["task_state"], c.taskState)
+				}
+				if c.agentID != "" {
+					metric.AddTag(tagNames["agent_id"], c.agentID)
 				}
-				metric.AddTag("task_name", c.taskName)
 			} else {
-				nonCachedIDs[cid] = true
-				stale = true
+				if !dm.isUnknown(unknown, cid) {
+					nonCachedIDs[cid] = true
+					stale = true
+				}
+				if dm.DropUnenriched && warm {
+					continue
+				}
 			}
 		}
+		out = append(out, metric)
 	}
 
 	if stale {
@@ -111,12 +211,98 @@ func (dm *DCOSMetadata) Apply(in ...telegraf.Metric) []telegraf.Metric {
 		go dm.refresh(cids...)
 	}
 
-	return in
+	return out
+}
+
+// buildTagNames maps each default tag name to the name it is configured to be
+// renamed to, or to itself if it is not renamed. Names must be non-empty and
+// must not collide with one another.
+func buildTagNames(configured map[string]string) (map[string]string, error) {
+	names := map[string]string{}
+	for _, name := range defaultTagNames {
+		names[name] = name
+	}
+
+	for from, to := range configured {
+		if _, ok := names[from]; !ok {
+			return nil, fmt.Errorf("tag_names: %q is not a tag added by dcos_metadata", from)
+		}
+		if to == "" {
+			return nil, fmt.Errorf("tag_names: %q cannot be renamed to an empty string", from)
+		}
+		names[from] = to
+	}
+
+	renamedFrom := map[string]string{}
+	for _, from := range defaultTagNames {
+		to := names[from]
+		if other, ok := renamedFrom[to]; ok {
+			return nil, fmt.Errorf("tag_names: %q and %q cannot both be named %q", other, from, to)
+		}
+		renamedFrom[to] = from
+	}
+	return names, nil
+}
+
+// hasExpired returns true if any container info in the cache is older than
+// the cache expiry, in which case the cache should be refreshed
+func (dm *DCOSMetadata) hasExpired(containers map[string]containerInfo) bool {
+	expiry := dm.CacheExpiry.Duration
+	if expiry <= 0 {
+		return false
+	}
+	for _, c := range containers {
+		if time.Since(c.fetchedAt) > expiry {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnknown returns true if the container ID was not found in mesos state
+// within the negative cache TTL, in which case refreshing is pointless
+func (dm *DCOSMetadata) isUnknown(unknown map[string]time.Time, cid string) bool {
+	lastSeen, ok := unknown[cid]
+	return ok && time.Since(lastSeen) <= dm.NegativeCacheTTL.Duration
+}
+
+// markUnknown records which of the given container IDs are still missing from
+// the cache after a refresh, so that their metrics do not trigger another
+// refresh until the negative cache TTL expires. Expired entries are evicted.
+// Like cache, it replaces the map rather than mutating it.
+func (dm *DCOSMetadata) markUnknown(cids []string) {
+	ttl := dm.NegativeCacheTTL.Duration
+	if ttl <= 0 {
+		return
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	now := time.Now()
+	unknown := map[string]time.Time{}
+	for cid, lastSeen := range dm.unknown {
+		if _, ok := dm.containers[cid]; ok || now.Sub(lastSeen) > ttl {
+			continue
+		}
+		unknown[cid] = lastSeen
+	}
+	for _, cid := range cids {
+		if _, ok := dm.containers[cid]; ok {
+			continue
+		}
+		if _, ok := unknown[cid]; !ok {
+			log.Printf("I! Container %q was not found in mesos state; not looking for it again for %s", cid, ttl)
+			unknown[cid] = now
+		}
+	}
+	dm.unknown = unknown
 }
 
 // refresh triggers a call to Mesos state. Calls to refresh are throttled by
-// the rate_limit option in configuration. Optionally, the container IDs which
-// caused the refresh may be passed in to be logged.
+// the rate_limit option in configuration, backing off exponentially while
+// refreshes fail. Optionally, the container IDs which caused the refresh may
+// be passed in to be logged.
 func (dm *DCOSMetadata) refresh(cids ...string) {
 	whitelistMap := map[string]bool{}
 	for _, label := range dm.Whitelist {
@@ -124,37 +310,101 @@ func (dm *DCOSMetadata) refresh(cids ...string) {
 	}
 
 	dm.once.Do(func() {
-		// Subsequent calls to refresh() will be ignored until the RateLimit period
-		// has expired
-		go func() {
-			time.Sleep(dm.RateLimit.Duration)
-			dm.once.Reset()
-		}()
-
 		for _, cid := range cids {
 			log.Printf("I! Metadata for container %q was not found in cache", cid)
 		}
 
-		client, err := dm.getClient()
+		err := dm.update(whitelistMap, cids)
 		if err != nil {
 			log.Printf("E! %s", err)
-			return
 		}
 
-		cli := httpagent.NewSender(client.Send)
-		ctx, cancel := context.WithTimeout(context.Background(), dm.Timeout.Duration)
-		defer cancel()
+		// Subsequent calls to refresh() will be ignored until the delay has
+		// expired
+		delay := dm.backoff(err != nil)
+		go func() {
+			time.Sleep(delay)
+			dm.once.Reset()
+		}()
+	})
+}
 
-		state, err := dm.getState(ctx, cli)
+// update retrieves state from every mesos agent and caches it. Agents which
+// cannot be reached are skipped; an error is returned only if none could be.
+func (dm *DCOSMetadata) update(whitelist map[string]bool, cids []string) error {
+	states := []*agent.Response_GetState{}
+	for _, url := range dm.agentUrls() {
+		state, err := dm.getAgentState(url)
 		if err != nil {
-			log.Printf("E! %s", err)
-			return
+			log.Printf("E! Could not retrieve state from mesos agent %s: %s", url, err)
+			continue
 		}
-		err = dm.cache(state, whitelistMap)
-		if err != nil {
-			log.Printf("E! %s", err)
-		}
-	})
+		states = append(states, state)
+	}
+	if len(states) == 0 {
+		return errors.New("could not retrieve state from any mesos agent")
+	}
+
+	if err := dm.cache(states, whitelist); err != nil {
+		return err
+	}
+	dm.markUnknown(cids)
+	return nil
+}
+
+// agentUrls returns the URLs of the mesos agents to retrieve state from
+func (dm *DCOSMetadata) agentUrls() []string {
+	if len(dm.MesosAgentUrls) > 0 {
+		return dm.MesosAgentUrls
+	}
+	return []string{dm.MesosAgentUrl}
+}
+
+// getAgentState requests state from the mesos agent at url
+func (dm *DCOSMetadata) getAgentState(url string) (*agent.Response_GetState, error) {
+	client, err := dm.getClient(url)
+	if err != nil {
+		return nil, err
+	}
+
+	cli := httpagent.NewSender(client.Send)
+	ctx, cancel := context.WithTimeout(context.Background(), dm.Timeout.Duration)
+	defer cancel()
+
+	return dm.getState(ctx, cli)
+}
+
+// backoff returns the period to wait before allowing the next refresh. After
+// a first failed refresh, the period is the rate limit, or minRefreshBackoff
+// if there is none; it doubles with each further consecutive failure, up to
+// maxRefreshBackoff, and returns to the rate limit once a refresh succeeds.
+// It must only be called from within dm.once.
+func (dm *DCOSMetadata) backoff(failed bool) time.Duration {
+	if !failed {
+		dm.failures = 0
+		dm.refreshDelay = dm.RateLimit.Duration
+		return dm.refreshDelay
+	}
+
+	dm.failures++
+	delay := dm.RateLimit.Duration
+	if delay <= 0 {
+		delay = minRefreshBackoff
+	}
+	if dm.failures > 1 && dm.refreshDelay > 0 {
+		delay = dm.refreshDelay * 2
+	}
+
+	limit := maxRefreshBackoff
+	if dm.RateLimit.Duration > limit {
+		limit = dm.RateLimit.Duration
+	}
+	if delay > limit {
+		delay = limit
+	}
+	dm.refreshDelay = delay
+	log.Printf("W! %d consecutive refreshes failed; not refreshing again for %s", dm.failures, delay)
+	return delay
 }
 
 // getState requests state from the operator API
@@ -176,18 +426,30 @@ func (dm *DCOSMetadata) getState(ctx context.Context, cli calls.Sender) (*agent.
 	return gs, nil
 }
 
-// cache caches container info from state
-func (dm *DCOSMetadata) cache(gs *agent.Response_GetState,
+// cache caches container info from the state of one or more agents
+func (dm *DCOSMetadata) cache(states []*agent.Response_GetState,
 	whitelist map[string]bool) error {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
 	containers := map[string]containerInfo{}
+	fetchedAt := time.Now()
 
+	for _, gs := range states {
+		dm.cacheState(containers, gs, whitelist, fetchedAt)
+	}
+
+	dm.containers = containers
+	return nil
+}
+
+// cacheState adds container info from the state of a single agent to
+// containers
+func (dm *DCOSMetadata) cacheState(containers map[string]containerInfo, gs *agent.Response_GetState,
+	whitelist map[string]bool, fetchedAt time.Time) {
 	gt := gs.GetGetTasks()
-	if gt == nil { // no tasks are running on the cluster
-		dm.containers = containers
-		return nil
+	if gt == nil { // no tasks are running on the agent
+		return
 	}
 
 	// map frameworks and executors in advance to avoid iterating
@@ -196,64 +458,75 @@ func (dm *DCOSMetadata) cache(gs *agent.Response_GetState,
 	executorNames := mapExecutorNames(gs.GetGetExecutors())
 
 	for _, t := range gt.GetLaunchedTasks() {
-		cid, pcid := getContainerIDs(t.GetStatuses())
+		cid, pcids := getContainerIDs(t.GetStatuses())
 		eName := ""
 		// ExecutorID is _not_ guaranteed not to be nil (FrameworkID is)
 		if eid := t.GetExecutorID(); eid != nil {
 			eName = executorNames[eid.Value]
 		}
 
-		// If container ID could not be found, don't add a nil entry
+		// If container ID could not be found, don't add a nil entry. A task's
+		// own entry replaces any ancestor entry added for another task.
 		if cid != "" {
 			containers[cid] = containerInfo{
 				containerID:   cid,
+				taskID:        t.GetTaskID().Value,
 				taskName:      t.GetName(),
+				taskState:     getTaskState(t),
 				executorName:  eName,
 				frameworkName: frameworkNames[t.GetFrameworkID().Value],
+				agentID:       t.GetAgentID().Value,
 				taskLabels:    mapTaskLabels(t.GetLabels(), whitelist, dm.WhitelistPrefix),
+				fetchedAt:     fetchedAt,
 			}
 		}
-		if pcid != "" {
+		// Every ancestor container belongs to the task's executor. An
+		// ancestor may itself be another task's container, whose entry is
+		// kept.
+		for _, pcid := range pcids {
+			if _, ok := containers[pcid]; ok {
+				continue
+			}
 			containers[pcid] = containerInfo{
 				containerID:   pcid,
 				executorName:  eName,
 				frameworkName: frameworkNames[t.GetFrameworkID().Value],
+				agentID:       t.GetAgentID().Value,
+				fetchedAt:     fetchedAt,
 			}
 		}
 	}
-
-	dm.containers = containers
-	return nil
 }
 
-// getClient returns the *httpcli.Client configured to make requests to Mesos that is a member of dm. If it hasn't been
-// created yet, it is created and then returned.
-func (dm *DCOSMetadata) getClient() (*httpcli.Client, error) {
-	if dm.client == nil {
-		client, err := dcosutil.MesosClient(dm.MesosAgentUrl, dm.DCOSConfig)
+// getClient returns the *httpcli.Client configured to make requests to the Mesos agent at url that is a member of dm.
+// If it hasn't been created yet, it is created and then returned.
+func (dm *DCOSMetadata) getClient(url string) (*httpcli.Client, error) {
+	if dm.clients == nil {
+		dm.clients = map[string]*httpcli.Client{}
+	}
+	if _, ok := dm.clients[url]; !ok {
+		client, err := dcosutil.MesosClient(url, dm.DCOSConfig)
 		if err != nil {
 			return nil, err
 		}
-		dm.client = client
+		dm.clients[url] = client
 	}
-	return dm.client, nil
+	return dm.clients[url], nil
 }
 
-// getContainerIDs retrieves the container ID and the parent container ID of a
-// task from its TaskStatus. The container ID corresponds to the task's
-// container, the parent container ID corresponds to the task's executor's
-// container. If there is no parent container ID, the task is the
-// executor (uses default executor).
-func getContainerIDs(statuses []mesos.TaskStatus) (containerID string, parentContainerID string) {
+// getContainerIDs retrieves the container ID and the IDs of every ancestor
+// container of a task from its TaskStatus. The container ID corresponds to the
+// task's container; the ancestors, nearest first, correspond to the containers
+// of the task's executor. If there are no ancestor container IDs, the task is
+// the executor (uses default executor).
+func getContainerIDs(statuses []mesos.TaskStatus) (containerID string, parentContainerIDs []string) {
 	// Container ID is held in task status
 	for _, s := range statuses {
 		if cs := s.GetContainerStatus(); cs != nil {
-			// TODO (philipnrmn) account for deeply-nested containers
 			if cid := cs.GetContainerID(); cid != nil {
 				containerID = cid.GetValue()
-				if pcid := cid.GetParent(); pcid != nil {
-					parentContainerID = pcid.GetValue()
-					return
+				for pcid := cid.GetParent(); pcid != nil; pcid = pcid.GetParent() {
+					parentContainerIDs = append(parentContainerIDs, pcid.GetValue())
 				}
 				return
 			}
@@ -262,6 +535,20 @@ func getContainerIDs(statuses []mesos.TaskStatus) (containerID string, parentCon
 	return
 }
 
+// getTaskState returns the state of the most recent of a task's statuses,
+// falling back to the state of the task itself if it has no statuses
+func getTaskState(t mesos.Task) string {
+	state := t.GetState()
+	latest := 0.0
+	for _, s := range t.GetStatuses() {
+		if ts := s.GetTimestamp(); ts >= latest {
+			latest = ts
+			state = s.GetState()
+		}
+	}
+	return state.String()
+}
+
 // mapFrameworkNames returns a map of framework ids and names
 func mapFrameworkNames(gf *agent.Response_GetFrameworks) map[string]string {
 	results := map[string]string{}
@@ -343,8 +630,10 @@ func processResponse(resp mesos.Response, t agent.Response_Type) (agent.Response
 func init() {
 	processors.Add("dcos_metadata", func() telegraf.Processor {
 		return &DCOSMetadata{
-			Timeout:   internal.Duration{Duration: 10 * time.Second},
-			RateLimit: internal.Duration{Duration: 5 * time.Second},
+			Timeout:          internal.Duration{Duration: 10 * time.Second},
+			RateLimit:        internal.Duration{Duration: 5 * time.Second},
+			CacheExpiry:      internal.Duration{Duration: 5 * time.Minute},
+			NegativeCacheTTL: internal.Duration{Duration: time.Minute},
 		}
 	})
 }
@@ -1,15 +1,25 @@
 package dcos_metadata
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/metric"
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// testAgentID is the ID of the agent on which every task in testdata runs
+const testAgentID = "577637a3-cbf2-4f38-a227-578b0783eabf-S1"
+
 type testCase struct {
 	fixture                    string
 	whitelist, whitelistPrefix []string
@@ -57,12 +67,48 @@ var (
 				),
 			},
 			cachedContainers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{"FOO": "bar", "BAZ": "qux"}},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux"}},
+			},
+			containers: map[string]containerInfo{
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux"}},
+			},
+		},
+		// One metric, cached state including the agent and task; agent_id, task_id and task_state tags are added
+		{
+			fixture: "normal",
+			inputs: []telegraf.Metric{
+				newMetric("test",
+					map[string]string{"container_id": "abc123"},
+					map[string]interface{}{"value": int64(1)},
+					time.Now(),
+				),
+			},
+			expected: []telegraf.Metric{
+				newMetric("test",
+					map[string]string{
+						"container_id":  "abc123",
+						"service_name":  "framework",
+						"executor_name": "executor",
+						"task_name":     "task",
+						"agent_id":      testAgentID,
+						"task_id":       "task.id",
+						"task_state":    "TASK_RUNNING",
+					},
+					map[string]interface{}{"value": int64(1)},
+					time.Now(),
+				),
+			},
+			cachedContainers: map[string]containerInfo{
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID: testAgentID, taskLabels: map[string]string{}},
 			},
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{"FOO": "bar", "BAZ": "qux"}},
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID: testAgentID, taskLabels: map[string]string{}},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (no additional whitelisted tags)
@@ -86,9 +132,11 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID: testAgentID,
 					// No whitelist/whitelisted prefixes configured
-					map[string]string{}},
+					taskLabels: map[string]string{}},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (with prefix-whitelisted tags)
@@ -113,9 +161,11 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID: testAgentID,
 					// Ensure that the tags are picked up from state, including whitelisted DCOS_METRICS_-prefixed ones
-					map[string]string{"FOO": "bar", "BAZ": "qux"}},
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux"}},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (with a whitelisted tag,
@@ -141,9 +191,11 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID: testAgentID,
 					// Ensure that the tags are picked up from state, including whitelisted "WHITELISTED_METRIC" tag
-					map[string]string{"WHITELISTED_METRIC": "foobar"}},
+					taskLabels: map[string]string{"WHITELISTED_METRIC": "foobar"}},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (
@@ -170,9 +222,35 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID: testAgentID,
 					// Ensure that the tags are picked up from state, including all whitelisted ones
-					map[string]string{"FOO": "bar", "BAZ": "qux", "WHITELISTED_METRIC": "foobar"}},
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux", "WHITELISTED_METRIC": "foobar"}},
+			},
+		},
+		// One metric, no cached state; state is updated with the latest task state
+		{
+			fixture: "killing",
+			inputs: []telegraf.Metric{
+				newMetric("test",
+					map[string]string{"container_id": "abc123"},
+					map[string]interface{}{"value": int64(1)},
+					time.Now(),
+				),
+			},
+			expected: []telegraf.Metric{
+				newMetric("test",
+					map[string]string{"container_id": "abc123"},
+					map[string]interface{}{"value": int64(1)},
+					time.Now(),
+				),
+			},
+			cachedContainers: map[string]containerInfo{},
+			containers: map[string]containerInfo{
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_KILLING",
+					executorName: "executor", frameworkName: "framework",
+					agentID: testAgentID, taskLabels: map[string]string{}},
 			},
 		},
 		// One metric without a container ID; nothing to do
@@ -219,10 +297,13 @@ var (
 			// We do expect the cache to be updated when apply is done
 			// Parent container (executor) is fetched along with task
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{}},
-				"xyz123": {"xyz123", "", "executor", "framework",
-					nil},
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID:    testAgentID,
+					taskLabels: map[string]string{}},
+				"xyz123": {containerID: "xyz123", taskName: "", executorName: "executor", frameworkName: "framework",
+					agentID:    testAgentID,
+					taskLabels: nil},
 			},
 		},
 		// Fetching a nested container ID; cached
@@ -264,17 +345,50 @@ var (
 				),
 			},
 			cachedContainers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{}},
-				"xyz123": {"xyz123", "", "executor", "framework",
-					nil},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{}},
+				"xyz123": {containerID: "xyz123", taskName: "", executorName: "executor", frameworkName: "framework",
+					taskLabels: nil},
 			},
 			// We do not expect the cache to be updated
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{}},
-				"xyz123": {"xyz123", "", "executor", "framework",
-					nil},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{}},
+				"xyz123": {containerID: "xyz123", taskName: "", executorName: "executor", frameworkName: "framework",
+					taskLabels: nil},
+			},
+		},
+		// Fetching a deeply nested container ID; not cached
+		{
+			fixture:         "deeply_nested",
+			whitelistPrefix: []string{"DCOS_METRICS_"},
+			inputs: []telegraf.Metric{
+				newMetric("test",
+					map[string]string{"container_id": "abc123"},
+					map[string]interface{}{"value": int64(1)},
+					time.Now(),
+				),
+			},
+			expected: []telegraf.Metric{
+				newMetric("test",
+					map[string]string{"container_id": "abc123"},
+					map[string]interface{}{"value": int64(1)},
+					time.Now(),
+				),
+			},
+			cachedContainers: map[string]containerInfo{},
+			// Every ancestor container is fetched along with the task
+			containers: map[string]containerInfo{
+				"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+					executorName: "executor", frameworkName: "framework",
+					agentID:    testAgentID,
+					taskLabels: map[string]string{}},
+				"xyz123": {containerID: "xyz123", taskName: "", executorName: "executor", frameworkName: "framework",
+					agentID:    testAgentID,
+					taskLabels: nil},
+				"def456": {containerID: "def456", taskName: "", executorName: "executor", frameworkName: "framework",
+					agentID:    testAgentID,
+					taskLabels: nil},
 			},
 		},
 		// No executor;
@@ -301,12 +415,12 @@ var (
 				),
 			},
 			cachedContainers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "", "framework",
-					map[string]string{}},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "", frameworkName: "framework",
+					taskLabels: map[string]string{}},
 			},
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "", "framework",
-					map[string]string{}},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "", frameworkName: "framework",
+					taskLabels: map[string]string{}},
 			},
 		},
 	}
@@ -343,13 +457,430 @@ func TestApply(t *testing.T) {
 	}
 }
 
+// TestApplyDuringRefresh should be run with -race; it calls Apply repeatedly
+// while the container cache is being refreshed
+func TestApplyDuringRefresh(t *testing.T) {
+	server, teardown := startTestServer(t, "normal")
+	defer teardown()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl:   server.URL,
+		Timeout:         internal.Duration{Duration: 100 * time.Millisecond},
+		WhitelistPrefix: []string{"DCOS_METRICS_"},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			// with no rate limit, every call to refresh fetches state
+			dm.refresh()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		dm.Apply(newMetric("test",
+			map[string]string{"container_id": "abc123"},
+			map[string]interface{}{"value": int64(1)},
+			time.Now(),
+		))
+	}
+	wg.Wait()
+
+	expected := map[string]containerInfo{
+		"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+			executorName: "executor", frameworkName: "framework",
+			agentID:    testAgentID,
+			taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux"}},
+	}
+	waitForContainersToEqual(t, &dm, expected, 100*time.Millisecond)
+}
+
+func TestApplyCacheExpiry(t *testing.T) {
+	server, teardown := startTestServer(t, "fresh")
+	defer teardown()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 100 * time.Millisecond},
+		RateLimit:     internal.Duration{Duration: 50 * time.Millisecond},
+		CacheExpiry:   internal.Duration{Duration: time.Minute},
+		containers: map[string]containerInfo{
+			// an expired entry for a container whose ID was reused
+			"abc123": {containerID: "abc123", taskName: "old-task", executorName: "old-executor",
+				frameworkName: "old-framework", fetchedAt: time.Now().Add(-time.Hour)},
+			// an entry for a container which no longer exists
+			"xyz123": {containerID: "xyz123", taskName: "gone", executorName: "gone",
+				frameworkName: "gone", fetchedAt: time.Now().Add(-time.Hour)},
+		},
+	}
+
+	input := func() telegraf.Metric {
+		return newMetric("test",
+			map[string]string{"container_id": "abc123"},
+			map[string]interface{}{"value": int64(1)},
+			time.Now(),
+		)
+	}
+
+	// Expired metadata is applied until it has been replaced
+	outputs := dm.Apply(input())
+	assert.Equal(t, "old-task", outputs[0].Tags()["task_name"])
+
+	// Expired entries were replaced by fresh metadata
+	expected := map[string]containerInfo{
+		"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+			executorName: "executor", frameworkName: "framework",
+			agentID:    testAgentID,
+			taskLabels: map[string]string{}},
+	}
+	waitForContainersToEqual(t, &dm, expected, 100*time.Millisecond)
+	// waitForContainersToEqual leaves the cache locked
+	assert.WithinDuration(t, time.Now(), dm.containers["abc123"].fetchedAt, time.Minute)
+	dm.mu.Unlock()
+
+	outputs = dm.Apply(input())
+	assert.Equal(t, "task", outputs[0].Tags()["task_name"])
+}
+
+func TestApplyCacheExpiryRefreshFails(t *testing.T) {
+	var requests int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl:  failing.URL,
+		Timeout:        internal.Duration{Duration: 100 * time.Millisecond},
+		RateLimit:      internal.Duration{Duration: 50 * time.Millisecond},
+		CacheExpiry:    internal.Duration{Duration: time.Minute},
+		DropUnenriched: true,
+		containers: map[string]containerInfo{
+			"abc123": {containerID: "abc123", taskName: "task", executorName: "executor",
+				frameworkName: "framework", fetchedAt: time.Now().Add(-time.Hour)},
+		},
+	}
+
+	input := func() telegraf.Metric {
+		return newMetric("test",
+			map[string]string{"container_id": "abc123"},
+			map[string]interface{}{"value": int64(1)},
+			time.Now(),
+		)
+	}
+
+	// Expired metadata triggers a refresh
+	outputs := dm.Apply(input())
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "task", outputs[0].Tags()["task_name"])
+	require.True(t, waitFor(func() bool { return atomic.LoadInt32(&requests) > 0 }, time.Second))
+
+	// The refresh failed, so expired metadata is still applied rather than
+	// the metric being dropped
+	outputs = dm.Apply(input())
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "task", outputs[0].Tags()["task_name"])
+}
+
+func TestApplyNegativeCache(t *testing.T) {
+	server, teardown := startTestServer(t, "fresh")
+	defer teardown()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl:    server.URL,
+		Timeout:          internal.Duration{Duration: 100 * time.Millisecond},
+		RateLimit:        internal.Duration{Duration: 50 * time.Millisecond},
+		NegativeCacheTTL: internal.Duration{Duration: 300 * time.Millisecond},
+	}
+	apply := func() {
+		dm.Apply(newMetric("test",
+			// a container which is not present in mesos state
+			map[string]string{"container_id": "system"},
+			map[string]interface{}{"value": int64(1)},
+			time.Now(),
+		))
+	}
+	lastSeen := func() time.Time {
+		dm.mu.RLock()
+		defer dm.mu.RUnlock()
+		return dm.unknown["system"]
+	}
+
+	apply()
+	assert.True(t, waitFor(func() bool { return !lastSeen().IsZero() }, 100*time.Millisecond),
+		"Unknown container was not negatively cached")
+	first := lastSeen()
+
+	// Once the rate limit has passed, the unknown container does not trigger
+	// another refresh
+	time.Sleep(100 * time.Millisecond)
+	apply()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, first, lastSeen())
+
+	// Once the negative cache TTL has passed, it is looked for again
+	time.Sleep(250 * time.Millisecond)
+	apply()
+	assert.True(t, waitFor(func() bool { return lastSeen().After(first) }, 100*time.Millisecond),
+		"Unknown container was not looked for again after the negative cache TTL")
+}
+
+func TestApplyTagNames(t *testing.T) {
+	dm := DCOSMetadata{
+		TagNames: map[string]string{
+			"service_name":  "framework",
+			"task_name":     "task",
+			"executor_name": "executor",
+		},
+		containers: map[string]containerInfo{
+			"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+				taskLabels: map[string]string{"FOO": "bar"}},
+		},
+	}
+	assert.Nil(t, dm.Init())
+
+	outputs := dm.Apply(newMetric("test",
+		map[string]string{"container_id": "abc123"},
+		map[string]interface{}{"value": int64(1)},
+		time.Now(),
+	))
+
+	assert.Equal(t, map[string]string{
+		"container_id": "abc123",
+		"framework":    "framework",
+		"task":         "task",
+		"executor":     "executor",
+		"FOO":          "bar",
+	}, outputs[0].Tags())
+}
+
+func TestBuildTagNames(t *testing.T) {
+	names, err := buildTagNames(map[string]string{"task_name": "task"})
+	assert.Nil(t, err)
+	assert.Equal(t, "task", names["task_name"])
+	// Unspecified tags keep their default names
+	assert.Equal(t, "service_name", names["service_name"])
+
+	invalid := []map[string]string{
+		// Not a tag added by the plugin
+		{"container_id": "container"},
+		// Empty
+		{"task_name": ""},
+		// Collides with another renamed tag
+		{"task_name": "name", "executor_name": "name"},
+		// Collides with a default tag name
+		{"task_name": "service_name"},
+	}
+	for _, configured := range invalid {
+		_, err := buildTagNames(configured)
+		assert.Error(t, err, "%v should be invalid", configured)
+
+		// An invalid configuration is rejected when the plugin is initialized
+		dm := DCOSMetadata{TagNames: configured}
+		assert.Error(t, dm.Init(), "%v should be rejected", configured)
+	}
+}
+
+func TestApplyDropUnenriched(t *testing.T) {
+	server, teardown := startTestServer(t, "fresh")
+	defer teardown()
+
+	inputs := func() []telegraf.Metric {
+		return []telegraf.Metric{
+			newMetric("cached", map[string]string{"container_id": "abc123"},
+				map[string]interface{}{"value": int64(1)}, time.Now()),
+			newMetric("uncached", map[string]string{"container_id": "xyz123"},
+				map[string]interface{}{"value": int64(1)}, time.Now()),
+			newMetric("unrelated", map[string]string{},
+				map[string]interface{}{"value": int64(1)}, time.Now()),
+		}
+	}
+	names := func(metrics []telegraf.Metric) []string {
+		results := []string{}
+		for _, m := range metrics {
+			results = append(results, m.Name())
+		}
+		return results
+	}
+	cached := func() map[string]containerInfo {
+		return map[string]containerInfo{
+			"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+				fetchedAt: time.Now()},
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		dropUnenriched bool
+		containers     map[string]containerInfo
+		expected       []string
+	}{
+		{"disabled", false, cached(), []string{"cached", "uncached", "unrelated"}},
+		{"enabled", true, cached(), []string{"cached", "unrelated"}},
+		// Nothing is dropped until the cache has been populated
+		{"enabled, cold cache", true, nil, []string{"cached", "uncached", "unrelated"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := DCOSMetadata{
+				MesosAgentUrl:  server.URL,
+				Timeout:        internal.Duration{Duration: 100 * time.Millisecond},
+				RateLimit:      internal.Duration{Duration: 50 * time.Millisecond},
+				DropUnenriched: tc.dropUnenriched,
+				containers:     tc.containers,
+			}
+			assert.Equal(t, tc.expected, names(dm.Apply(inputs()...)))
+		})
+	}
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl: failing.URL,
+		Timeout:       internal.Duration{Duration: 100 * time.Millisecond},
+		RateLimit:     internal.Duration{Duration: 10 * time.Millisecond},
+	}
+
+	// The delay starts at the rate limit and doubles with each consecutive
+	// failure
+	for _, expected := range []time.Duration{10, 20, 40} {
+		dm.once.Reset()
+		dm.refresh()
+		assert.Equal(t, expected*time.Millisecond, dm.refreshDelay)
+	}
+	assert.Equal(t, 3, dm.failures)
+
+	// The delay returns to the rate limit once a refresh succeeds
+	server, teardown := startTestServer(t, "fresh")
+	defer teardown()
+	dm.MesosAgentUrl = server.URL
+
+	dm.once.Reset()
+	dm.refresh()
+	assert.Equal(t, 10*time.Millisecond, dm.refreshDelay)
+	assert.Equal(t, 0, dm.failures)
+
+	// The delay is capped
+	dm.failures = 1
+	dm.refreshDelay = maxRefreshBackoff
+	assert.Equal(t, maxRefreshBackoff, dm.backoff(true))
+}
+
+func TestRefreshBackoffWithoutRateLimit(t *testing.T) {
+	dm := DCOSMetadata{}
+
+	// Without a rate limit, the delay starts at minRefreshBackoff rather
+	// than 0, and doubles with each consecutive failure
+	assert.Equal(t, minRefreshBackoff, dm.backoff(true))
+	assert.Equal(t, 2*minRefreshBackoff, dm.backoff(true))
+	assert.Equal(t, 4*minRefreshBackoff, dm.backoff(true))
+
+	// The delay returns to 0 once a refresh succeeds
+	assert.Equal(t, time.Duration(0), dm.backoff(false))
+	assert.Equal(t, minRefreshBackoff, dm.backoff(true))
+}
+
+func TestApplyMultipleAgents(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	server, teardown := startTestServer(t, "nested")
+	defer teardown()
+
+	dm := DCOSMetadata{
+		MesosAgentUrls: []string{failing.URL, server.URL},
+		Timeout:        internal.Duration{Duration: 100 * time.Millisecond},
+		RateLimit:      internal.Duration{Duration: 50 * time.Millisecond},
+	}
+
+	dm.Apply(newMetric("test",
+		map[string]string{"container_id": "abc123"},
+		map[string]interface{}{"value": int64(1)},
+		time.Now(),
+	))
+
+	// State from the healthy agent was cached in spite of the failing one
+	expected := map[string]containerInfo{
+		"abc123": {containerID: "abc123", taskName: "task", taskID: "task.id", taskState: "TASK_RUNNING",
+			executorName: "executor", frameworkName: "framework",
+			agentID: testAgentID, taskLabels: map[string]string{}},
+		"xyz123": {containerID: "xyz123", executorName: "executor", frameworkName: "framework",
+			agentID: testAgentID},
+	}
+	waitForContainersToEqual(t, &dm, expected, 200*time.Millisecond)
+}
+
+func TestCacheStateNestedTasks(t *testing.T) {
+	// parent's container is an ancestor of task's container
+	var resp agent.Response
+	if err := resp.Unmarshal(loadFixture(t, "nested_tasks/state.bin")); err != nil {
+		t.Fatal(err)
+	}
+	gs := resp.GetGetState()
+	launched := gs.GetTasks.LaunchedTasks
+
+	// Tasks are cached correctly whichever order they are listed in
+	for _, order := range [][]int{{0, 1}, {1, 0}} {
+		gs.GetTasks.LaunchedTasks = []mesos.Task{launched[order[0]], launched[order[1]]}
+		containers := map[string]containerInfo{}
+		dm := DCOSMetadata{}
+		dm.cacheState(containers, gs, map[string]bool{}, time.Now())
+
+		assert.Equal(t, "task", containers["abc123"].taskName)
+		assert.Equal(t, "task.id", containers["abc123"].taskID)
+		assert.Equal(t, "parent", containers["xyz123"].taskName)
+		assert.Equal(t, "parent.id", containers["xyz123"].taskID)
+		assert.Equal(t, "", containers["def456"].taskName)
+		assert.Equal(t, "executor", containers["def456"].executorName)
+	}
+}
+
 func TestGetClient(t *testing.T) {
 	dm := DCOSMetadata{}
-	client1, err1 := dm.getClient()
-	client2, err2 := dm.getClient()
+	client1, err1 := dm.getClient("http://198.51.100.1:5051")
+	client2, err2 := dm.getClient("http://198.51.100.1:5051")
+	client3, err3 := dm.getClient("http://198.51.100.2:5051")
 	assert.Nil(t, err1)
 	assert.Nil(t, err2)
+	assert.Nil(t, err3)
 	assert.Equal(t, client1, client2)
+	assert.Len(t, dm.clients, 2)
+	assert.NotNil(t, client3)
+}
+
+func TestMapTaskLabels(t *testing.T) {
+	value := func(v string) *string { return &v }
+	labels := &mesos.Labels{Labels: []mesos.Label{
+		{Key: "DCOS_METRICS_FOO", Value: value("bar")},
+		{Key: "MYTEAM_BAZ", Value: value("qux")},
+		{Key: "MYTEAM_", Value: value("empty")},
+		{Key: "OTHER", Value: value("label")},
+	}}
+
+	// A custom prefix is stripped from the label, however long it is
+	assert.Equal(t, map[string]string{"BAZ": "qux"},
+		mapTaskLabels(labels, map[string]bool{}, []string{"MYTEAM_"}))
+
+	// An empty prefix promotes every label unchanged
+	assert.Equal(t, map[string]string{
+		"DCOS_METRICS_FOO": "bar",
+		"MYTEAM_BAZ":       "qux",
+		"MYTEAM_":          "empty",
+		"OTHER":            "label",
+	}, mapTaskLabels(labels, map[string]bool{}, []string{""}))
+
+	// No prefixes promote only whitelisted labels
+	assert.Equal(t, map[string]string{"OTHER": "label"},
+		mapTaskLabels(labels, map[string]bool{"OTHER": true}, []string{}))
 }
 
 // newMetric is a convenience method which allows us to define test cases at
@@ -381,10 +912,34 @@ func waitForContainersToEqual(t *testing.T, dm *DCOSMetadata, expected map[strin
 
 	select {
 	case <-done:
-		assert.Equal(t, expected, dm.containers)
+		assert.Equal(t, expected, withoutFetchTimes(dm.containers))
 		return
 	case <-time.After(timeout):
 		assert.Fail(t, "Timed out waiting for a container update")
 		return
 	}
 }
+
+// waitFor polls condition until it is true or the timeout passes, and returns
+// whether it became true
+func waitFor(condition func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
+
+// withoutFetchTimes returns a copy of the container cache with fetch times
+// cleared, so that it can be compared with expected values
+func withoutFetchTimes(containers map[string]containerInfo) map[string]containerInfo {
+	results := map[string]containerInfo{}
+	for cid, c := range containers {
+		c.fetchedAt = time.Time{}
+		results[cid] = c
+	}
+	return results
+}
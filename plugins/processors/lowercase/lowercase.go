@@ -1,22 +1,76 @@
 package lowercase
 
 import (
+	"fmt"
+	"log"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/filter"
 	"github.com/influxdata/telegraf/plugins/processors"
 )
 
 type Lowercase struct {
-	SendOriginal bool `toml:"send_original"`
+	SendOriginal       bool     `toml:"send_original"`
+	LowercaseTags      bool     `toml:"lowercase_tags"`
+	LowercaseTagValues bool     `toml:"lowercase_tag_values"`
+	Include            []string `toml:"include"`
+	Exclude            []string `toml:"exclude"`
+	Case               string   `toml:"case"`
+	Measurements       []string `toml:"measurements"`
+	Collision          string   `toml:"collision"`
+
+	initialized       bool
+	fieldFilter       filter.Filter
+	measurementFilter filter.Filter
+	// convert coerces a string to the configured case, and opposite holds
+	// the letters which are not in that case
+	convert  func(string) string
+	opposite string
+
+	collisions           int
+	lastCollisionWarning time.Time
 }
 
-const capitals = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+const (
+	capitals = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	smalls   = "abcdefghijklmnopqrstuvwxyz"
+
+	collisionWarningInterval = time.Minute
+)
 
 var sampleConfig = `
   ## Sends both Some_Metric and some_metric if true. 
   ## If false, sends only some_metric.
   # send_original = false
+
+  ## Lowercase tag keys as well as metric names and field keys.
+  # lowercase_tags = true
+
+  ## Lowercase tag values. Beware that some values, such as IDs, paths and
+  ## URLs, may be case-sensitive.
+  # lowercase_tag_values = false
+
+  ## Field keys to lowercase; other field keys keep their case. The lists may
+  ## contain globs. If include is empty, all field keys are included.
+  # include = []
+  # exclude = []
+
+  ## The case to coerce metrics to; either "lower" or "upper". In upper mode,
+  ## the options above apply to uppercasing instead.
+  # case = "lower"
+
+  ## Measurements to coerce; other metrics pass through untouched. The list
+  ## may contain globs. If empty, all metrics are coerced.
+  # measurements = []
+
+  ## What to do when converting a field or tag key collides with an existing
+  ## key, such as Load and load: "overwrite" the existing value, "keep" the
+  ## existing value and drop the other, or "rename" the other key with a
+  ## numeric suffix, such as load_1.
+  # collision = "overwrite"
 `
 
 func (l *Lowercase) SampleConfig() string {
@@ -24,50 +78,178 @@ func (l *Lowercase) SampleConfig() string {
 }
 
 func (l *Lowercase) Description() string {
-	return "Coerce all metrics that pass through this filter to lowercase."
+	return "Coerce all metrics that pass through this filter to lowercase, or to uppercase."
 }
 
 func (l *Lowercase) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	if !l.initialized {
+		err := l.compile()
+		if err != nil {
+			log.Printf("E! [processors.lowercase] initialization error: %v", err)
+			return in
+		}
+	}
+
 	out := make([]telegraf.Metric, 0, len(in))
 
 	for _, metric := range in {
-		// Optimisation: only test for uppercase metrics if we wish to
-		// preserve the original metric.
-		if l.SendOriginal && isUpper(metric) {
+		if l.measurementFilter != nil && !l.measurementFilter.Match(metric.Name()) {
+			out = append(out, metric)
+			continue
+		}
+
+		// Optimisation: only test for metrics in the opposite case if we
+		// wish to preserve the original metric.
+		if l.SendOriginal && l.hasOppositeCase(metric) {
 			out = append(out, metric.Copy())
 		}
 
-		out = append(out, toLower(metric))
+		out = append(out, l.convertCase(metric))
 	}
 
 	return out
 }
 
-func isUpper(metric telegraf.Metric) bool {
-	if strings.ContainsAny(metric.Name(), capitals) {
+func (l *Lowercase) compile() error {
+	f, err := filter.NewIncludeExcludeFilter(l.Include, l.Exclude)
+	if err != nil {
+		return err
+	}
+	l.fieldFilter = f
+
+	l.measurementFilter, err = filter.Compile(l.Measurements)
+	if err != nil {
+		return err
+	}
+
+	switch l.Case {
+	case "", "lower":
+		l.convert, l.opposite = strings.ToLower, capitals
+	case "upper":
+		l.convert, l.opposite = strings.ToUpper, smalls
+	default:
+		return fmt.Errorf("unknown case %q; expected \"lower\" or \"upper\"", l.Case)
+	}
+
+	switch l.Collision {
+	case "", "overwrite", "keep", "rename":
+	default:
+		return fmt.Errorf("unknown collision %q; expected \"overwrite\", \"keep\" or \"rename\"", l.Collision)
+	}
+
+	l.initialized = true
+	return nil
+}
+
+func (l *Lowercase) hasOppositeCase(metric telegraf.Metric) bool {
+	if strings.ContainsAny(metric.Name(), l.opposite) {
 		return true
 	}
 	for key := range metric.Fields() {
-		if strings.ContainsAny(key, capitals) {
+		if l.fieldFilter.Match(key) && strings.ContainsAny(key, l.opposite) {
+			return true
+		}
+	}
+	for key, value := range metric.Tags() {
+		if l.LowercaseTags && strings.ContainsAny(key, l.opposite) {
+			return true
+		}
+		if l.LowercaseTagValues && strings.ContainsAny(value, l.opposite) {
 			return true
 		}
 	}
 	return false
 }
 
-func toLower(metric telegraf.Metric) telegraf.Metric {
-	metric.SetName(strings.ToLower(metric.Name()))
-	for key, value := range metric.Fields() {
+func (l *Lowercase) convertCase(metric telegraf.Metric) telegraf.Metric {
+	metric.SetName(l.convert(metric.Name()))
+
+	// Keys are converted in order so that collisions resolve predictably
+	fields := metric.Fields()
+	fieldKeys := make([]string, 0, len(fields))
+	for key := range fields {
+		fieldKeys = append(fieldKeys, key)
+	}
+	sort.Strings(fieldKeys)
+	for _, key := range fieldKeys {
+		newKey := l.convert(key)
+		if newKey == key || !l.fieldFilter.Match(key) {
+			continue
+		}
 		// The metric interface does not expose fields; we
 		// therefore remove and re-add the affected key.
 		metric.RemoveField(key)
-		metric.AddField(strings.ToLower(key), value)
+		if metric.HasField(newKey) {
+			l.warnCollision("field", key, newKey, metric.Name())
+			switch l.Collision {
+			case "keep":
+				continue
+			case "rename":
+				newKey = renamed(newKey, metric.HasField)
+			}
+		}
+		metric.AddField(newKey, fields[key])
+	}
+
+	if l.LowercaseTags || l.LowercaseTagValues {
+		tags := metric.Tags()
+		tagKeys := make([]string, 0, len(tags))
+		for key := range tags {
+			tagKeys = append(tagKeys, key)
+		}
+		sort.Strings(tagKeys)
+		for _, key := range tagKeys {
+			newKey, newValue := key, tags[key]
+			if l.LowercaseTags {
+				newKey = l.convert(key)
+			}
+			if l.LowercaseTagValues {
+				newValue = l.convert(newValue)
+			}
+			if newKey == key && newValue == tags[key] {
+				continue
+			}
+			metric.RemoveTag(key)
+			if newKey != key && metric.HasTag(newKey) {
+				l.warnCollision("tag", key, newKey, metric.Name())
+				switch l.Collision {
+				case "keep":
+					continue
+				case "rename":
+					newKey = renamed(newKey, metric.HasTag)
+				}
+			}
+			metric.AddTag(newKey, newValue)
+		}
 	}
 	return metric
 }
 
+// warnCollision records that converting key collided with an existing key,
+// logging at most one warning per collisionWarningInterval
+func (l *Lowercase) warnCollision(kind, key, newKey, name string) {
+	l.collisions++
+	if time.Since(l.lastCollisionWarning) < collisionWarningInterval {
+		return
+	}
+	log.Printf("W! [processors.lowercase] %s %q of metric %q collides with %s %q (%d collisions since the last warning)",
+		kind, key, name, kind, newKey, l.collisions)
+	l.lastCollisionWarning = time.Now()
+	l.collisions = 0
+}
+
+// renamed returns key with the lowest numeric suffix which does not exist
+func renamed(key string, exists func(string) bool) string {
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_%d", key, i)
+		if !exists(candidate) {
+			return candidate
+		}
+	}
+}
+
 func init() {
 	processors.Add("lowercase", func() telegraf.Processor {
-		return &Lowercase{}
+		return &Lowercase{LowercaseTags: true}
 	})
 }
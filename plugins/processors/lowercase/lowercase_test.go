@@ -77,6 +77,212 @@ func TestApply_SendOriginals(t *testing.T) {
 	}, output[2].Fields())
 }
 
+// With lowercase_tags enabled, tag keys are lowercased; tag values are not
+func TestApply_LowercaseTags(t *testing.T) {
+	tags := map[string]string{"Host": "Server01", "REGION": "us-west", "lower": "Value"}
+	input, _ := metric.New("unchanged", tags, fields["unchanged"], time.Now())
+
+	lc := Lowercase{LowercaseTags: true}
+	output := lc.Apply(input)
+	assert.Equal(t, 1, len(output))
+
+	assert.Equal(t, map[string]string{
+		"host":   "Server01",
+		"region": "us-west",
+		"lower":  "Value",
+	}, output[0].Tags())
+}
+
+// Without lowercase_tags, tag keys are left alone
+func TestApply_LowercaseTagsDisabled(t *testing.T) {
+	tags := map[string]string{"Host": "Server01"}
+	input, _ := metric.New("unchanged", tags, fields["unchanged"], time.Now())
+
+	lc := Lowercase{}
+	output := lc.Apply(input)
+	assert.Equal(t, map[string]string{"Host": "Server01"}, output[0].Tags())
+}
+
+// Tag keys which lowercase to the same key collapse into a single tag
+func TestApply_LowercaseTagsCollision(t *testing.T) {
+	tags := map[string]string{"Host": "Server01", "host": "server01"}
+	input, _ := metric.New("unchanged", tags, fields["unchanged"], time.Now())
+
+	lc := Lowercase{LowercaseTags: true}
+	output := lc.Apply(input)
+
+	assert.Equal(t, 1, len(output[0].Tags()))
+	assert.Equal(t, "Server01", output[0].Tags()["host"])
+}
+
+// With SendOriginals enabled, metrics with uppercase tag keys are duplicated
+func TestApply_LowercaseTagsSendOriginals(t *testing.T) {
+	tags := map[string]string{"Host": "Server01"}
+	input, _ := metric.New("unchanged", tags, fields["unchanged"], time.Now())
+
+	lc := Lowercase{SendOriginal: true, LowercaseTags: true}
+	output := lc.Apply(input)
+	assert.Equal(t, 2, len(output))
+
+	assert.Equal(t, map[string]string{"Host": "Server01"}, output[0].Tags())
+	assert.Equal(t, map[string]string{"host": "Server01"}, output[1].Tags())
+}
+
+// With lowercase_tag_values enabled, tag values are lowercased independently
+// of tag keys
+func TestApply_LowercaseTagValues(t *testing.T) {
+	tags := map[string]string{"Env": "PROD", "region": "us-West"}
+
+	input, _ := metric.New("unchanged", tags, fields["unchanged"], time.Now())
+	lc := Lowercase{LowercaseTagValues: true}
+	output := lc.Apply(input)
+	assert.Equal(t, map[string]string{"Env": "prod", "region": "us-west"}, output[0].Tags())
+
+	input, _ = metric.New("unchanged", tags, fields["unchanged"], time.Now())
+	lc = Lowercase{LowercaseTags: true, LowercaseTagValues: true}
+	output = lc.Apply(input)
+	assert.Equal(t, map[string]string{"env": "prod", "region": "us-west"}, output[0].Tags())
+
+	// Values are left alone when the flag is not set
+	input, _ = metric.New("unchanged", tags, fields["unchanged"], time.Now())
+	lc = Lowercase{LowercaseTags: true}
+	output = lc.Apply(input)
+	assert.Equal(t, map[string]string{"env": "PROD", "region": "us-West"}, output[0].Tags())
+}
+
+// With include set, only matching field keys are lowercased
+func TestApply_Include(t *testing.T) {
+	input, _ := metric.New("ChAnGeD", map[string]string{}, fields["ChAnGeD"], time.Now())
+
+	lc := Lowercase{Include: []string{"UPPER_*"}}
+	output := lc.Apply(input)
+
+	assert.Equal(t, "changed", output[0].Name())
+	assert.Equal(t, map[string]interface{}{
+		"lower_case": "abc123",
+		"upper_case": "ABC123",
+		"Mixed_Case": "Abc123",
+	}, output[0].Fields())
+}
+
+// With exclude set, matching field keys keep their case
+func TestApply_Exclude(t *testing.T) {
+	input, _ := metric.New("ChAnGeD", map[string]string{}, fields["ChAnGeD"], time.Now())
+
+	lc := Lowercase{Exclude: []string{"UPPER_*"}}
+	output := lc.Apply(input)
+
+	assert.Equal(t, "changed", output[0].Name())
+	assert.Equal(t, map[string]interface{}{
+		"lower_case": "abc123",
+		"UPPER_CASE": "ABC123",
+		"mixed_case": "Abc123",
+	}, output[0].Fields())
+}
+
+// In upper mode, metrics are uppercased instead
+func TestApply_Upper(t *testing.T) {
+	tags := map[string]string{"host": "server01"}
+	input, _ := metric.New("ChAnGeD", tags, fields["ChAnGeD"], time.Now())
+
+	lc := Lowercase{Case: "upper", LowercaseTags: true}
+	output := lc.Apply(input)
+	assert.Equal(t, 1, len(output))
+
+	assert.Equal(t, "CHANGED", output[0].Name())
+	assert.Equal(t, map[string]interface{}{
+		"LOWER_CASE": "abc123",
+		"UPPER_CASE": "ABC123",
+		"MIXED_CASE": "Abc123",
+	}, output[0].Fields())
+	assert.Equal(t, map[string]string{"HOST": "server01"}, output[0].Tags())
+}
+
+// In upper mode with SendOriginals enabled, metrics containing lowercase are
+// duplicated
+func TestApply_UpperSendOriginals(t *testing.T) {
+	inputs := make([]telegraf.Metric, 2)
+	inputs[0], _ = metric.New("ChAnGeD", map[string]string{}, fields["ChAnGeD"], time.Now())
+	inputs[1], _ = metric.New("UNCHANGED", map[string]string{},
+		map[string]interface{}{"UPPER_CASE": "ABC123"}, time.Now())
+
+	lc := Lowercase{Case: "upper", SendOriginal: true}
+	output := lc.Apply(inputs...)
+	assert.Equal(t, 3, len(output))
+
+	assert.Equal(t, "ChAnGeD", output[0].Name())
+	assert.Equal(t, fields["ChAnGeD"], output[0].Fields())
+
+	assert.Equal(t, "CHANGED", output[1].Name())
+	assert.Equal(t, "UNCHANGED", output[2].Name())
+	assert.Equal(t, map[string]interface{}{
+		"UPPER_CASE": "ABC123",
+	}, output[2].Fields())
+}
+
+// An unknown case leaves metrics untouched
+func TestApply_UnknownCase(t *testing.T) {
+	input, _ := metric.New("ChAnGeD", map[string]string{}, fields["ChAnGeD"], time.Now())
+
+	lc := Lowercase{Case: "title"}
+	output := lc.Apply(input)
+	assert.Equal(t, 1, len(output))
+	assert.Equal(t, "ChAnGeD", output[0].Name())
+}
+
+// With measurements set, only matching metrics are coerced or duplicated
+func TestApply_Measurements(t *testing.T) {
+	inputs := make([]telegraf.Metric, 3)
+	inputs[0], _ = metric.New("ChAnGeD", map[string]string{}, fields["ChAnGeD"], time.Now())
+	inputs[1], _ = metric.New("Skipped", map[string]string{}, fields["ChAnGeD"], time.Now())
+	inputs[2], _ = metric.New("unchanged", map[string]string{}, fields["unchanged"], time.Now())
+
+	lc := Lowercase{SendOriginal: true, Measurements: []string{"ChAnGeD", "un*"}}
+	output := lc.Apply(inputs...)
+	assert.Equal(t, 4, len(output))
+
+	assert.Equal(t, "ChAnGeD", output[0].Name())
+	assert.Equal(t, "changed", output[1].Name())
+
+	assert.Equal(t, "Skipped", output[2].Name())
+	assert.Equal(t, fields["ChAnGeD"], output[2].Fields())
+
+	assert.Equal(t, "unchanged", output[3].Name())
+}
+
+// Keys which collide after lowercasing are resolved according to collision
+func TestApply_Collision(t *testing.T) {
+	testCases := []struct {
+		collision string
+		expected  map[string]interface{}
+	}{
+		{"", map[string]interface{}{"load": int64(1)}},
+		{"overwrite", map[string]interface{}{"load": int64(1)}},
+		{"keep", map[string]interface{}{"load": int64(2)}},
+		{"rename", map[string]interface{}{"load": int64(2), "load_1": int64(1)}},
+	}
+	for _, tc := range testCases {
+		input, _ := metric.New("collision", map[string]string{},
+			map[string]interface{}{"Load": int64(1), "load": int64(2)}, time.Now())
+
+		lc := Lowercase{Collision: tc.collision}
+		output := lc.Apply(input)
+		assert.Equal(t, tc.expected, output[0].Fields(), "collision = %q", tc.collision)
+		// The collision was logged
+		assert.False(t, lc.lastCollisionWarning.IsZero())
+	}
+}
+
+// Colliding tag keys are renamed too
+func TestApply_CollisionTags(t *testing.T) {
+	tags := map[string]string{"Host": "Server01", "host": "server01"}
+	input, _ := metric.New("unchanged", tags, fields["unchanged"], time.Now())
+
+	lc := Lowercase{LowercaseTags: true, Collision: "rename"}
+	output := lc.Apply(input)
+	assert.Equal(t, map[string]string{"host": "server01", "host_1": "Server01"}, output[0].Tags())
+}
+
 // The following two tests demonstrate that using strings.ContainsAny is ~6
 // times faster than a compiled regexp MatchString.
 
@@ -10,3 +10,11 @@ type Processor interface {
 	// Apply the filter to the given metric.
 	Apply(in ...Metric) []Metric
 }
+
+// Initializer is an interface that a Processor can optionally implement in
+// order to validate its configuration once it has been loaded.
+type Initializer interface {
+	// Init is called once the configuration has been loaded, and returns an
+	// error if it is invalid.
+	Init() error
+}